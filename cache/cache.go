@@ -1,27 +1,44 @@
 package cache
 
 import (
-	"github.com/hashicorp/golang-lru/simplelru"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/tarent/go-log-middleware/v2/logging"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Cache is a LRU cache with the following features
+// - selectable eviction policies, e.g. LFU or 2Q instead of LRU
 // - limits on max entries
 // - memory size limit
-// - ttl for entries
+// - ttl for entries, limited by a cache wide maxAge
 type Cache struct {
 	name             string
 	lock             sync.RWMutex
-	lruBackend       *simplelru.LRU
+	lruBackend       Backend
 	maxAge           time.Duration
+	staleRetention   time.Duration
+	maxEntries       int
 	maxSizeBytes     int
 	currentSizeBytes int
 	hits             int
 	misses           int
+	totalHits        int // the hits before the current reporting period
+	totalMisses      int // the misses before the current reporting period
+	evictions        int
 	stats            map[string]interface{}
+
+	// evicting is true, while entries are removed because of the size limits in Set()
+	evicting      bool
+	evicted       []Entry
+	evictListener func(entry Entry)
+
+	// budget is the size limit shared with other caches, e.g. the shards of a ShardedCache.
+	// If it is nil, the cache is limited by its maxSizeBytes.
+	budget *sizeBudget
 }
 
 type CacheEntry struct {
@@ -29,20 +46,44 @@ type CacheEntry struct {
 	label       string
 	size        int
 	fetchTime   time.Time
+	expiry      time.Time
+	tags        []string
 	cacheObject interface{}
 	hits        int
 }
 
-// NewCache creates a new cache
+// Entry is a cache entry with its object, e.g. to move it to another cache.
+type Entry struct {
+	Key       string      `json:"key"`
+	Label     string      `json:"label"`
+	SizeBytes int         `json:"size_bytes"`
+	Expiry    time.Time   `json:"expiry"`
+	Tags      []string    `json:"tags,omitempty"`
+	Object    interface{} `json:"-"`
+}
+
+// PurgedEntry describes an entry, which was removed by a selective purge.
+type PurgedEntry struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// NewCache creates a new cache, which evicts the least recently used entries
 func NewCache(name string, maxEntries int, maxSizeMB int, maxAge time.Duration) *Cache {
+	return NewCacheWithPolicy(name, maxEntries, maxSizeMB, maxAge, LRUPolicy)
+}
+
+// NewCacheWithPolicy creates a new cache, which evicts the entries by the policy
+func NewCacheWithPolicy(name string, maxEntries int, maxSizeMB int, maxAge time.Duration, policy EvictionPolicy) *Cache {
 	c := &Cache{
 		name:         name,
 		maxAge:       maxAge,
+		maxEntries:   maxEntries,
 		maxSizeBytes: maxSizeMB * 1024 * 1024,
 	}
 
 	var err error
-	c.lruBackend, err = simplelru.NewLRU(maxEntries, simplelru.EvictCallback(c.onEvicted))
+	c.lruBackend, err = policy(maxEntries, c.onEvicted)
 	if err != nil {
 		panic(err)
 	}
@@ -82,8 +123,11 @@ func (c *Cache) calculateStats(reportingDuration time.Duration) {
 		"cache_hits":               c.hits,
 		"cache_misses":             c.misses,
 		"cache_hit_ratio":          ratio,
+		"cache_evictions":          c.evictions,
 	}
 
+	c.totalHits += c.hits
+	c.totalMisses += c.misses
 	c.hits = 0
 	c.misses = 0
 	logging.Logger.
@@ -97,7 +141,7 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	e, found := c.lruBackend.Get(key)
 	if found {
 		entry := e.(*CacheEntry)
-		if time.Since(entry.fetchTime) < c.maxAge {
+		if time.Now().Before(entry.expiry) {
 			entry.hits++
 			c.hits++
 			return entry.cacheObject, true
@@ -107,25 +151,99 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	return nil, false
 }
 
-func (c *Cache) Set(key string, label string, sizeBytes int, cacheObject interface{}) {
+// GetStale returns the entry, even if it is out of its ttl.
+// staleFor is the time since the entry has expired, or <= 0 if the entry is fresh.
+// Only fresh entries are counted as hits.
+func (c *Cache) GetStale(key string) (cacheObject interface{}, staleFor time.Duration, found bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, found := c.lruBackend.Get(key)
+	if !found {
+		c.misses++
+		return nil, 0, false
+	}
+	entry := e.(*CacheEntry)
+	staleFor = time.Since(entry.expiry)
+	if staleFor < 0 {
+		entry.hits++
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return entry.cacheObject, staleFor, true
+}
+
+// SetStaleRetention keeps entries for the supplied duration after their ttl in the cache,
+// so that they can be served stale by GetStale(). The default is 0.
+func (c *Cache) SetStaleRetention(staleRetention time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.staleRetention = staleRetention
+}
+
+// SetEvictionListener sets a function, which is called with the entries,
+// which are removed from the cache because of its limits of entries or size.
+// It is not called for entries, which are purged, invalidated or replaced.
+func (c *Cache) SetEvictionListener(listener func(entry Entry)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.evictListener = listener
+}
+
+// Set puts the object into the cache, until its expiry.
+// The expiry is limited by the maxAge of the cache, which is also used, if the expiry is the zero time.
+// The tags are stored with the entry, to purge it by PurgeByTag().
+func (c *Cache) Set(key string, label string, sizeBytes int, cacheObject interface{}, expiry time.Time, tags ...string) {
+	now := time.Now()
+	if maxExpiry := now.Add(c.maxAge); expiry.IsZero() || expiry.After(maxExpiry) {
+		expiry = maxExpiry
+	}
 	entry := &CacheEntry{
 		key:         key,
 		label:       label,
 		size:        sizeBytes,
-		fetchTime:   time.Now(),
+		fetchTime:   now,
+		expiry:      expiry,
+		tags:        tags,
 		cacheObject: cacheObject,
 	}
 	c.lock.Lock()
-	defer c.lock.Unlock()
 
-	// first remove, to have correct size counting
-	c.lruBackend.Remove(key)
+	// an existing entry is replaced, to keep its usage for the eviction policy
+	if e, found := c.lruBackend.Peek(key); found {
+		c.resize(-e.(*CacheEntry).size)
+	}
 
-	c.currentSizeBytes += sizeBytes
-	c.lruBackend.Add(key, entry)
+	c.resize(sizeBytes)
+	c.evicting = true
+	if evicted := c.lruBackend.Add(key, entry); evicted {
+		c.evictions++
+	}
 
-	for c.currentSizeBytes > c.maxSizeBytes {
+	for c.oversize() {
+		// with a shared budget, the new entry is kept and the other caches have to make room
+		if c.budget != nil && c.lruBackend.Len() <= 1 {
+			break
+		}
 		c.lruBackend.RemoveOldest()
+		c.evictions++
+	}
+	c.evicting = false
+
+	evicted, listener := c.evicted, c.evictListener
+	c.evicted = nil
+	c.lock.Unlock()
+
+	notifyEvicted(listener, evicted)
+}
+
+// notifyEvicted calls the listener with the evicted entries.
+// It is called without lock, so that the listener may access the cache.
+func notifyEvicted(listener func(entry Entry), evicted []Entry) {
+	if listener != nil {
+		for _, e := range evicted {
+			listener(e)
+		}
 	}
 }
 
@@ -135,30 +253,59 @@ func (c *Cache) Set(key string, label string, sizeBytes int, cacheObject interfa
 //     will be triggered as a subcall of Set()
 func (c *Cache) onEvicted(key, value interface{}) {
 	entry := value.(*CacheEntry)
-	c.currentSizeBytes -= entry.size
+	c.resize(-entry.size)
+	if c.evicting && c.evictListener != nil {
+		c.evicted = append(c.evicted, entry.entry())
+	}
+}
+
+// resize changes the size of the cache and of its shared budget.
+// The method has to be called in a locked mutex block.
+func (c *Cache) resize(deltaBytes int) {
+	c.currentSizeBytes += deltaBytes
+	if c.budget != nil {
+		atomic.AddInt64(&c.budget.currentSizeBytes, int64(deltaBytes))
+	}
+}
+
+// oversize returns true, if the cache or its shared budget exceeds the size limit.
+func (c *Cache) oversize() bool {
+	if c.budget != nil {
+		return c.budget.oversize()
+	}
+	return c.currentSizeBytes > c.maxSizeBytes
+}
+
+// removeOldest removes the least recently used entry as eviction and returns false, if the cache is empty.
+func (c *Cache) removeOldest() bool {
+	c.lock.Lock()
+	c.evicting = true
+	_, _, removed := c.lruBackend.RemoveOldest()
+	if removed {
+		c.evictions++
+	}
+	c.evicting = false
+
+	evicted, listener := c.evicted, c.evictListener
+	c.evicted = nil
+	c.lock.Unlock()
+
+	notifyEvicted(listener, evicted)
+	return removed
 }
 
-// PurgeOldEntries removes all entries which are out of their ttl
+// PurgeOldEntries removes all entries which are out of their ttl and stale retention
 func (c *Cache) PurgeOldEntries() {
-	c.lock.RLock()
+	c.lock.Lock()
 	keys := c.lruBackend.Keys()
-	c.lock.RUnlock()
 	purged := 0
 	for _, key := range keys {
-		c.lock.RLock()
-		e, found := c.lruBackend.Peek(key)
-		c.lock.RUnlock()
-
-		if found {
-			entry := e.(*CacheEntry)
-			if time.Since(entry.fetchTime) > c.maxAge {
-				c.lock.Lock()
-				c.lruBackend.Remove(key)
-				c.lock.Unlock()
-				purged++
-			}
+		if e, found := c.lruBackend.Peek(key); found && time.Since(e.(*CacheEntry).expiry) > c.staleRetention {
+			c.lruBackend.Remove(key)
+			purged++
 		}
 	}
+	c.lock.Unlock()
 	logging.Logger.
 		WithFields(logrus.Fields(c.stats)).
 		Infof("purged %v out of %v cache entries", purged, len(keys))
@@ -186,6 +333,46 @@ func (c *Cache) PurgeEntries(keys []string) {
 		Infof("Following cache entries become purged: %v", c.PurgedKeysAsString(keys))
 }
 
+// PurgeByLabel removes all entries with the label, e.g. the url.
+func (c *Cache) PurgeByLabel(label string) []PurgedEntry {
+	return c.purgeWhere(func(entry *CacheEntry) bool {
+		return entry.label == label
+	})
+}
+
+// PurgeByLabelPrefix removes all entries with a label, which starts with the prefix.
+func (c *Cache) PurgeByLabelPrefix(prefix string) []PurgedEntry {
+	return c.purgeWhere(func(entry *CacheEntry) bool {
+		return strings.HasPrefix(entry.label, prefix)
+	})
+}
+
+// PurgeByTag removes all entries, which were set with the tag.
+func (c *Cache) PurgeByTag(tag string) []PurgedEntry {
+	return c.purgeWhere(func(entry *CacheEntry) bool {
+		return contains(entry.tags, tag)
+	})
+}
+
+// purgeWhere removes all entries, which match.
+func (c *Cache) purgeWhere(match func(entry *CacheEntry) bool) []PurgedEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	purged := []PurgedEntry{}
+	for _, key := range c.lruBackend.Keys() {
+		e, found := c.lruBackend.Peek(key)
+		if !found {
+			continue
+		}
+		if entry := e.(*CacheEntry); match(entry) {
+			c.lruBackend.Remove(key)
+			purged = append(purged, PurgedEntry{Key: entry.key, Label: entry.label})
+		}
+	}
+	return purged
+}
+
 func (c *Cache) PurgedKeysAsString(keys []string) string {
 	count := 0
 	keyString := ""
@@ -221,3 +408,141 @@ func (c *Cache) Len() int {
 	defer c.lock.RUnlock()
 	return c.lruBackend.Len()
 }
+
+// Stats are the statistics of a cache, with the hits and misses since its creation.
+type Stats struct {
+	Name         string  `json:"name"`
+	Entries      int     `json:"entries"`
+	MaxEntries   int     `json:"max_entries"`
+	SizeBytes    int     `json:"size_bytes"`
+	MaxSizeBytes int     `json:"max_size_bytes"`
+	MaxAge       float64 `json:"max_age_seconds"`
+	Hits         int     `json:"hits"`
+	Misses       int     `json:"misses"`
+	HitRatio     int     `json:"hit_ratio"`
+	Evictions    int     `json:"evictions"`
+}
+
+// Stats returns the current statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := Stats{
+		Name:         c.name,
+		Entries:      c.lruBackend.Len(),
+		MaxEntries:   c.maxEntries,
+		SizeBytes:    c.currentSizeBytes,
+		MaxSizeBytes: c.maxSizeBytes,
+		MaxAge:       c.maxAge.Seconds(),
+		Hits:         c.totalHits + c.hits,
+		Misses:       c.totalMisses + c.misses,
+		HitRatio:     100,
+		Evictions:    c.evictions,
+	}
+	if stats.Hits+stats.Misses != 0 {
+		stats.HitRatio = 100 * stats.Hits / (stats.Hits + stats.Misses)
+	}
+	return stats
+}
+
+// EntryInfo describes an entry of the cache.
+type EntryInfo struct {
+	Key        string   `json:"key"`
+	Label      string   `json:"label"`
+	SizeBytes  int      `json:"size_bytes"`
+	Age        float64  `json:"age_seconds"`
+	TTL        float64  `json:"ttl_seconds"` // the remaining time to live, negative if the entry is expired
+	Hits       int      `json:"hits"`
+	Tags       []string `json:"tags,omitempty"`
+	ObjectType string   `json:"object_type"`
+}
+
+func (entry *CacheEntry) entry() Entry {
+	return Entry{
+		Key:       entry.key,
+		Label:     entry.label,
+		SizeBytes: entry.size,
+		Expiry:    entry.expiry,
+		Tags:      entry.tags,
+		Object:    entry.cacheObject,
+	}
+}
+
+func (entry *CacheEntry) info() EntryInfo {
+	return EntryInfo{
+		Key:        entry.key,
+		Label:      entry.label,
+		SizeBytes:  entry.size,
+		Age:        time.Since(entry.fetchTime).Seconds(),
+		TTL:        time.Until(entry.expiry).Seconds(),
+		Hits:       entry.hits,
+		Tags:       entry.tags,
+		ObjectType: fmt.Sprintf("%T", entry.cacheObject),
+	}
+}
+
+// Entries returns the descriptions of all entries, starting with those, which are evicted next.
+// This does neither change the order of the entries, nor the statistics.
+func (c *Cache) Entries() []EntryInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	infos := make([]EntryInfo, 0, c.lruBackend.Len())
+	for _, key := range c.lruBackend.Keys() {
+		if e, found := c.lruBackend.Peek(key); found {
+			infos = append(infos, e.(*CacheEntry).info())
+		}
+	}
+	return infos
+}
+
+// Entry returns the description of the entry with the key.
+// This does neither change the order of the entries, nor the statistics.
+func (c *Cache) Entry(key string) (EntryInfo, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	e, found := c.lruBackend.Peek(key)
+	if !found {
+		return EntryInfo{}, false
+	}
+	return e.(*CacheEntry).info(), true
+}
+
+// Remove removes the entry with the key and returns true, if it was found.
+func (c *Cache) Remove(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lruBackend.Remove(key)
+}
+
+// LiveEntries returns the entries, which are not expired, starting with those, which are evicted next.
+func (c *Cache) LiveEntries() []Entry {
+	now := time.Now()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	entries := make([]Entry, 0, c.lruBackend.Len())
+	for _, key := range c.lruBackend.Keys() {
+		if e, found := c.lruBackend.Peek(key); found && now.Before(e.(*CacheEntry).expiry) {
+			entries = append(entries, e.(*CacheEntry).entry())
+		}
+	}
+	return entries
+}
+
+// Restore puts the entry into the cache, if it is not expired and not in the cache, yet.
+func (c *Cache) Restore(entry Entry) bool {
+	if !time.Now().Before(entry.Expiry) {
+		return false
+	}
+	c.lock.RLock()
+	exists := c.lruBackend.Contains(entry.Key)
+	c.lock.RUnlock()
+	if exists {
+		return false
+	}
+	c.Set(entry.Key, entry.Label, entry.SizeBytes, entry.Object, entry.Expiry, entry.Tags...)
+	return true
+}
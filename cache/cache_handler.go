@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tarent/go-log-middleware/v2/logging"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const DefaultEntriesPageSize = 100
+
+// EntriesPage is a page of the entries of the cache.
+type EntriesPage struct {
+	Total   int         `json:"total"`
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+	Entries []EntryInfo `json:"entries"`
+}
+
+// CacheHandler is a http handler for the inspection of an InspectableCache. The routes are relative to the path,
+// where the handler is mounted:
+//
+//	GET    .../stats          the statistics of the cache
+//	GET    .../entries        the entries, filtered by the query parameter label (substring of the label)
+//	                          and paginated by the query parameters offset and limit
+//	GET    .../entries/{key}  the entry with the key
+//	DELETE .../entries/{key}  removes the entry with the key
+//	POST   .../snapshot       writes a snapshot of the cache, if configured by WithSnapshot()
+type CacheHandler struct {
+	cache              InspectableCache
+	snapshotFile       string
+	snapshotSerializer Serializer
+}
+
+// SnapshotResult is the response of a snapshot request.
+type SnapshotResult struct {
+	File    string `json:"file"`
+	Entries int    `json:"entries"`
+}
+
+func NewCacheHandler(cache InspectableCache) *CacheHandler {
+	return &CacheHandler{cache: cache}
+}
+
+// WithSnapshot enables snapshots of the cache on demand, written to the file with the serializer.
+func (ch *CacheHandler) WithSnapshot(file string, serializer Serializer) *CacheHandler {
+	ch.snapshotFile = file
+	ch.snapshotSerializer = serializer
+	return ch
+}
+
+func (ch *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case strings.HasSuffix(path, "/stats"):
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, r, ch.cache.Stats())
+
+	case strings.HasSuffix(path, "/entries"):
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		page, err := ch.entries(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, page)
+
+	case strings.Contains(path, "/entries/"):
+		key := path[strings.LastIndex(path, "/entries/")+len("/entries/"):]
+		ch.serveEntry(w, r, key)
+
+	case strings.HasSuffix(path, "/snapshot"):
+		ch.serveSnapshot(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// entries returns the page of the entries selected by the query parameters.
+func (ch *CacheHandler) entries(r *http.Request) (*EntriesPage, error) {
+	offset, err := intParameter(r, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParameter(r, "limit", DefaultEntriesPageSize)
+	if err != nil {
+		return nil, err
+	}
+	label := r.URL.Query().Get("label")
+
+	entries := []EntryInfo{}
+	for _, entry := range ch.cache.Entries() {
+		if strings.Contains(entry.Label, label) {
+			entries = append(entries, entry)
+		}
+	}
+
+	page := &EntriesPage{Total: len(entries), Offset: offset, Limit: limit, Entries: []EntryInfo{}}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Entries = entries[offset:end]
+	}
+	return page, nil
+}
+
+func (ch *CacheHandler) serveEntry(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		entry, found := ch.cache.Entry(key)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, r, entry)
+	case http.MethodDelete:
+		if !ch.cache.Remove(key) {
+			http.NotFound(w, r)
+			return
+		}
+		logging.Application(r.Header).Infof("cache entry %v was removed", key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (ch *CacheHandler) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if ch.snapshotFile == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	written, err := SaveSnapshot(ch.snapshotFile, ch.cache, ch.snapshotSerializer)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("error writing cache snapshot")
+		http.Error(w, "error writing cache snapshot", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, SnapshotResult{File: ch.snapshotFile, Entries: written})
+}
+
+// intParameter returns the non negative int value of the query parameter, or the default, if it is not present.
+func intParameter(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value for parameter %v: %q", name, value)
+	}
+	return i, nil
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Application(r.Header).WithError(err).Error("error writing cache information")
+	}
+}
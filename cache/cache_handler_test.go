@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_CacheHandler(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 100, 100, time.Hour)
+	c.Set("nav", "http://example.de/nav", 1, nil, time.Time{})
+	c.Set("teaser1", "http://example.de/teaser/1", 1, nil, time.Time{})
+	c.Set("teaser2", "http://example.de/teaser/2", 1, nil, time.Time{})
+	c.Get("nav")
+	handler := http.StripPrefix("/internal", NewCacheHandler(c))
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// stats
+	w := serve("GET", "/internal/cache/stats")
+	a.Equal(200, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	stats := Stats{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &stats))
+	a.Equal(3, stats.Entries)
+	a.Equal(1, stats.Hits)
+
+	// entries filtered by label and paginated
+	w = serve("GET", "/internal/cache/entries?label=teaser&offset=1&limit=10")
+	a.Equal(200, w.Code)
+	page := EntriesPage{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &page))
+	a.Equal(2, page.Total)
+	a.Equal(1, len(page.Entries))
+	a.Equal("teaser2", page.Entries[0].Key)
+
+	w = serve("GET", "/internal/cache/entries?offset=-1")
+	a.Equal(400, w.Code)
+
+	// single entry
+	w = serve("GET", "/internal/cache/entries/nav")
+	a.Equal(200, w.Code)
+	entry := EntryInfo{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &entry))
+	a.Equal("http://example.de/nav", entry.Label)
+	a.Equal(1, entry.Hits)
+
+	w = serve("DELETE", "/internal/cache/entries/nav")
+	a.Equal(204, w.Code)
+	w = serve("GET", "/internal/cache/entries/nav")
+	a.Equal(404, w.Code)
+	w = serve("DELETE", "/internal/cache/entries/nav")
+	a.Equal(404, w.Code)
+
+	w = serve("POST", "/internal/cache/stats")
+	a.Equal(405, w.Code)
+	w = serve("GET", "/internal/cache/foo")
+	a.Equal(404, w.Code)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/tarent/go-log-middleware/v2/logging"
 	"github.com/tarent/lib-compose/v2/util"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -103,7 +104,44 @@ func (tcs *CacheStrategy) HashWithParameters(method string, url string, requestH
 	return hash
 }
 
+// VaryHeaders returns the canonical names of the request headers out of the Vary header of the response.
+func VaryHeaders(responseHeader http.Header) []string {
+	var names []string
+	for _, value := range responseHeader["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// HashWithVary computes a hash value for a variant of the resource with the supplied hash,
+// based on the values of the request headers, the response varies on.
+func HashWithVary(hash string, varyHeaders []string, requestHeader http.Header) string {
+	hasher := md5.New()
+
+	hasher.Write([]byte(hash))
+	for _, h := range varyHeaders {
+		hasher.Write([]byte(h))
+		hasher.Write([]byte(strings.Join(requestHeader[h], ",")))
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// IsCacheable checks the cachability by the rules of RFC 7234. Responses with Vary: * are not cachable.
 func (tcs *CacheStrategy) IsCacheable(method string, url string, statusCode int, requestHeader http.Header, responseHeader http.Header) bool {
+	for _, name := range VaryHeaders(responseHeader) {
+		if name == "*" {
+			logging.Logger.WithField("notCachableReason", "Vary: *").
+				WithField("type", "cacheinfo").
+				Debugf("ressource not cachable %v %v: Vary: *", method, url)
+			return false
+		}
+	}
+
 	// TODO: it is expensive to create a request object only for passing to the cachecontrol library
 	req := &http.Request{Method: method, Header: requestHeader}
 	reasons, _, err := cacheobject.UsingRequestResponse(req, statusCode, responseHeader, true)
@@ -183,6 +183,26 @@ func Test_CacheStrategy_IsCachable(t *testing.T) {
 			},
 			false,
 		},
+		{
+			DefaultCacheStrategy,
+			"GET",
+			200,
+			nil,
+			http.Header{
+				"Vary": {"Accept-Language"},
+			},
+			true,
+		},
+		{
+			DefaultCacheStrategy,
+			"GET",
+			200,
+			nil,
+			http.Header{
+				"Vary": {"Accept-Encoding, *"},
+			},
+			false,
+		},
 	}
 
 	for _, t := range tests {
@@ -196,6 +216,21 @@ func Test_CacheStrategy_IsCachable(t *testing.T) {
 	}
 }
 
+func Test_CacheStrategy_Vary(t *testing.T) {
+	a := assert.New(t)
+
+	a.Nil(VaryHeaders(nil))
+	a.Equal([]string{"Accept-Language", "X-Feature-Toggle", "Cookie"},
+		VaryHeaders(http.Header{"Vary": {"accept-language, X-Feature-Toggle", "Cookie"}}))
+
+	varyHeaders := []string{"Accept-Language"}
+	de := HashWithVary("hash", varyHeaders, http.Header{"Accept-Language": {"de"}})
+	a.Equal(de, HashWithVary("hash", varyHeaders, http.Header{"Accept-Language": {"de"}, "Accept": {"text/html"}}))
+	a.NotEqual(de, HashWithVary("hash", varyHeaders, http.Header{"Accept-Language": {"en"}}))
+	a.NotEqual(de, HashWithVary("hash", varyHeaders, nil))
+	a.NotEqual(de, HashWithVary("other", varyHeaders, http.Header{"Accept-Language": {"de"}}))
+}
+
 func Test_CacheStrategy_readCookieValue(t *testing.T) {
 	a := assert.New(t)
 
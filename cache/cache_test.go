@@ -20,7 +20,7 @@ func Test_Cache_TTL(t *testing.T) {
 	c := NewCache("my-cache", 5, 100, time.Millisecond)
 
 	// when i store an entry
-	c.Set("foo", "", 0, "bar")
+	c.Set("foo", "", 0, "bar", time.Time{})
 
 	// then I can retrieve it
 	v, found := c.Get("foo")
@@ -35,15 +35,60 @@ func Test_Cache_TTL(t *testing.T) {
 	a.False(found)
 }
 
+func Test_Cache_TTL_PerEntry(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 5, 100, 20*time.Millisecond)
+
+	// an entry with a shorter expiry than the maxAge
+	c.Set("short", "", 0, "short", time.Now().Add(time.Millisecond))
+	// and an entry with a longer expiry than the maxAge
+	c.Set("long", "", 0, "long", time.Now().Add(time.Hour))
+
+	time.Sleep(5 * time.Millisecond)
+	_, found := c.Get("short")
+	a.False(found)
+	_, found = c.Get("long")
+	a.True(found)
+
+	// the maxAge is the upper bound
+	time.Sleep(20 * time.Millisecond)
+	_, found = c.Get("long")
+	a.False(found)
+}
+
+func Test_Cache_GetStale(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 5, 100, 10*time.Millisecond)
+	c.Set("foo", "", 0, "bar", time.Time{})
+
+	v, staleFor, found := c.GetStale("foo")
+	a.True(found)
+	a.Equal("bar", v.(string))
+	a.True(staleFor < 0)
+
+	time.Sleep(15 * time.Millisecond)
+
+	// the expired entry is still returned
+	v, staleFor, found = c.GetStale("foo")
+	a.True(found)
+	a.Equal("bar", v.(string))
+	a.True(staleFor >= 5*time.Millisecond)
+
+	_, _, found = c.GetStale("bazz")
+	a.False(found)
+}
+
 func Test_Cache_LRU_MaxEntries(t *testing.T) {
 	a := assert.New(t)
 
 	// given a cache of size 3
 	// with 3 entries
 	c := NewCache("my-cache", 3, 100, time.Hour)
-	c.Set("a", "", 0, "a")
-	c.Set("b", "", 0, "b")
-	c.Set("c", "", 0, "c")
+	c.Set("a", "", 0, "a", time.Time{})
+	c.Set("b", "", 0, "b", time.Time{})
+	c.Set("c", "", 0, "c", time.Time{})
 	a.Equal(3, c.Len())
 
 	// when I and access the oldest
@@ -52,7 +97,7 @@ func Test_Cache_LRU_MaxEntries(t *testing.T) {
 	a.Equal("a", v.(string))
 
 	// and add one more
-	c.Set("newcommer", "", 0, "newcommer")
+	c.Set("newcommer", "", 0, "newcommer", time.Time{})
 
 	// then the recently used are in
 	_, found = c.Get("a")
@@ -72,21 +117,21 @@ func Test_Cache_MaxBytes(t *testing.T) {
 
 	// given a cache with max 1 mega byte, filled with 8 bytes
 	c := NewCache("my-cache", 100, 1, time.Hour)
-	c.Set("a", "", 42*1024, "a")
-	c.Set("a", "", 400*1024, "a") // the same item only shoud count once, with the lastest bytes
-	c.Set("b", "", 400*1024, "b")
+	c.Set("a", "", 42*1024, "a", time.Time{})
+	c.Set("a", "", 400*1024, "a", time.Time{}) // the same item only shoud count once, with the lastest bytes
+	c.Set("b", "", 400*1024, "b", time.Time{})
 	a.Equal(800*1024, c.SizeByte())
 	a.Equal(2, c.Len())
 
 	// when I add and 2 more bytes
-	c.Set("c", "", 200*1024, "c")
+	c.Set("c", "", 200*1024, "c", time.Time{})
 
 	// then they fit
 	a.Equal(1000*1024, c.SizeByte())
 	a.Equal(3, c.Len())
 
 	// but when i add even more
-	c.Set("d", "", 200*1024, "c")
+	c.Set("d", "", 200*1024, "c", time.Time{})
 
 	// then the last accessed entry was taken out
 	a.Equal(800*1024, c.SizeByte())
@@ -103,7 +148,7 @@ func Test_Cache_Stats(t *testing.T) {
 	a := assert.New(t)
 
 	c := NewCache("my-cache", 3, 100, time.Hour)
-	c.Set("a", "", 42, "a")
+	c.Set("a", "", 42, "a", time.Time{})
 	c.Get("a")
 	c.Get("a")
 	c.Get("b")
@@ -116,16 +161,59 @@ func Test_Cache_Stats(t *testing.T) {
 	a.Equal(66, c.stats["cache_hit_ratio"])
 }
 
+func Test_Cache_StatsAndEntries(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 2, 100, time.Hour)
+	c.Set("a", "http://a", 42, "a", time.Time{}, "tag")
+	c.Set("b", "http://b", 1, "b", time.Now().Add(time.Minute))
+	c.Get("b")
+	c.calculateStats(time.Hour)
+	c.Get("b")
+	c.Get("x")
+	// evicts the least recently used entry a
+	c.Set("c", "http://c", 1, "c", time.Time{})
+
+	stats := c.Stats()
+	a.Equal("my-cache", stats.Name)
+	a.Equal(2, stats.Entries)
+	a.Equal(2, stats.SizeBytes)
+	a.Equal(2, stats.Hits)
+	a.Equal(1, stats.Misses)
+	a.Equal(66, stats.HitRatio)
+	a.Equal(1, stats.Evictions)
+
+	entries := c.Entries()
+	a.Equal(2, len(entries))
+	a.Equal("b", entries[0].Key)
+	a.Equal("c", entries[1].Key)
+	a.InDelta(60, entries[0].TTL, 1)
+	a.InDelta(3600, entries[1].TTL, 1)
+	a.Equal("string", entries[1].ObjectType)
+
+	entry, found := c.Entry("b")
+	a.True(found)
+	a.Equal("http://b", entry.Label)
+	a.Equal(1, entry.SizeBytes)
+	a.Equal(2, entry.Hits)
+
+	a.True(c.Remove("b"))
+	a.False(c.Remove("b"))
+	_, found = c.Entry("b")
+	a.False(found)
+	a.Equal(1, c.SizeByte())
+}
+
 func Test_Cache_PurgeOldEntries(t *testing.T) {
 	a := assert.New(t)
 
 	c := NewCache("my-cache", 100, 100, time.Millisecond)
-	c.Set("a", "", 1, "a")
-	c.Set("b", "", 1, "a")
-	c.Set("c", "", 1, "a")
+	c.Set("a", "", 1, "a", time.Time{})
+	c.Set("b", "", 1, "a", time.Time{})
+	c.Set("c", "", 1, "a", time.Time{})
 	time.Sleep(time.Millisecond)
-	c.Set("d", "", 42, "a")
-	c.Set("e", "", 42, "a")
+	c.Set("d", "", 42, "a", time.Time{})
+	c.Set("e", "", 42, "a", time.Time{})
 
 	c.PurgeOldEntries()
 
@@ -133,12 +221,25 @@ func Test_Cache_PurgeOldEntries(t *testing.T) {
 	a.Equal(84, c.SizeByte())
 }
 
+func Test_Cache_PurgeOldEntries_StaleRetention(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 100, 100, time.Millisecond)
+	c.SetStaleRetention(time.Hour)
+	c.Set("a", "", 1, "a", time.Time{})
+	time.Sleep(2 * time.Millisecond)
+
+	c.PurgeOldEntries()
+
+	a.Equal(1, c.Len())
+}
+
 func Test_Cache_PurgeEntries(t *testing.T) {
 	a := assert.New(t)
 
 	c := NewCache("my-cache", 100, 100, time.Millisecond)
-	c.Set("hashStringToPurge", "", 1, nil)
-	c.Set("hashStringToStay", "", 1, nil)
+	c.Set("hashStringToPurge", "", 1, nil, time.Time{})
+	c.Set("hashStringToStay", "", 1, nil, time.Time{})
 
 	c.PurgeEntries([]string{"hashStringToPurge"})
 
@@ -149,6 +250,28 @@ func Test_Cache_PurgeEntries(t *testing.T) {
 	a.True(foundInCacheStay)
 }
 
+func Test_Cache_PurgeSelected(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 100, 100, time.Hour)
+	c.Set("nav", "http://example.de/nav", 1, nil, time.Time{}, "layout")
+	c.Set("footer", "http://example.de/footer", 1, nil, time.Time{}, "layout", "legal")
+	c.Set("teaser", "http://example.de/teaser/1", 1, nil, time.Time{}, "prices")
+	c.Set("teaser2", "http://example.de/teaser/2", 1, nil, time.Time{}, "prices")
+
+	a.Equal([]PurgedEntry{{Key: "nav", Label: "http://example.de/nav"}}, c.PurgeByLabel("http://example.de/nav"))
+	a.Equal(3, c.Len())
+
+	a.Equal(2, len(c.PurgeByLabelPrefix("http://example.de/teaser/")))
+	a.Equal(1, c.Len())
+	a.Equal(1, c.SizeByte())
+
+	a.Equal([]PurgedEntry{}, c.PurgeByTag("prices"))
+	a.Equal([]PurgedEntry{{Key: "footer", Label: "http://example.de/footer"}}, c.PurgeByTag("legal"))
+	a.Equal(0, c.Len())
+	a.Equal(0, c.SizeByte())
+}
+
 func Test_Cache_purgedKeysAsString(t *testing.T) {
 	a := assert.New(t)
 
@@ -167,9 +290,9 @@ func Test_Cache_Invalidation(t *testing.T) {
 	// given a cache of size 3
 	// with 3 entries
 	c := NewCache("my-cache", 3, 100, time.Hour)
-	c.Set("a", "", 0, "a")
-	c.Set("b", "", 0, "b")
-	c.Set("c", "", 0, "c")
+	c.Set("a", "", 0, "a", time.Time{})
+	c.Set("b", "", 0, "b", time.Time{})
+	c.Set("c", "", 0, "c", time.Time{})
 	a.Equal(3, c.Len())
 
 	//when i empty the cache
@@ -0,0 +1,350 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/tarent/go-log-middleware/v2/logging"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const diskCacheFileSuffix = ".cache"
+
+// diskCacheTempPrefix is the prefix of the temporary files, which are written before they are renamed to the entry files.
+const diskCacheTempPrefix = "tmp-"
+
+// diskObjectType is the object type in the descriptions of the entries on disk, which are not read for this.
+const diskObjectType = "disk"
+
+// Serializer converts the objects of a cache to bytes and back, to store them on disk.
+type Serializer interface {
+	Serialize(cacheObject interface{}) ([]byte, error)
+	Deserialize(data []byte) (interface{}, error)
+}
+
+// DiskCache stores cache entries as files in a directory, limited by size and age.
+// Each file starts with a JSON line with the description of the entry, followed by the serialized object.
+// The descriptions are read on creation, so that the entries of a former process are available.
+// Temporary files of writes, which were interrupted in a former process, are deleted on creation.
+// The objects are read on each Get() and are not held in memory.
+type DiskCache struct {
+	dir          string
+	maxSizeBytes int
+	maxAge       time.Duration
+	serializer   Serializer
+
+	lock             sync.Mutex
+	lruBackend       *simplelru.LRU
+	currentSizeBytes int
+}
+
+// diskEntry is the description of an entry on disk.
+type diskEntry struct {
+	Entry
+	StoredAt time.Time `json:"stored_at"`
+	FileSize int       `json:"file_size"`
+}
+
+// NewDiskCache creates a DiskCache in the directory, which is created, if it does not exist.
+// The size of the files is limited by maxSizeMB and entries are removed maxAge after they were written,
+// independent of their expiry.
+func NewDiskCache(dir string, maxSizeMB int, maxAge time.Duration, serializer Serializer) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &DiskCache{
+		dir:          dir,
+		maxSizeBytes: maxSizeMB * 1024 * 1024,
+		maxAge:       maxAge,
+		serializer:   serializer,
+	}
+
+	var err error
+	d.lruBackend, err = simplelru.NewLRU(math.MaxInt32, simplelru.EvictCallback(d.onEvicted))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.readIndex(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// readIndex reads the descriptions of the existing files, from the oldest to the newest.
+// The temporary files of interrupted writes are deleted.
+func (d *DiskCache) readIndex() error {
+	tempFiles, err := filepath.Glob(filepath.Join(d.dir, diskCacheTempPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, file := range tempFiles {
+		os.Remove(file)
+	}
+
+	files, err := filepath.Glob(filepath.Join(d.dir, "*"+diskCacheFileSuffix))
+	if err != nil {
+		return err
+	}
+
+	entries := []*diskEntry{}
+	for _, file := range files {
+		entry, err := readDiskEntry(file)
+		if err != nil || time.Since(entry.StoredAt) > d.maxAge {
+			os.Remove(file)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	// the most recently stored entries are the most recently used
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StoredAt.Before(entries[j].StoredAt)
+	})
+	for _, entry := range entries {
+		d.currentSizeBytes += entry.FileSize
+		d.lruBackend.Add(entry.Key, entry)
+	}
+	d.removeOversize()
+	return nil
+}
+
+func readDiskEntry(file string) (*diskEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	entry := &diskEntry{}
+	return entry, json.Unmarshal(line, entry)
+}
+
+// Set writes the entry to disk.
+func (d *DiskCache) Set(entry Entry) error {
+	data, err := d.serializer.Serialize(entry.Object)
+	if err != nil {
+		return err
+	}
+	e := &diskEntry{Entry: entry, StoredAt: time.Now()}
+	header, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	e.FileSize = len(header) + 1 + len(data)
+	if e.FileSize > d.maxSizeBytes {
+		return fmt.Errorf("entry %v with %v bytes exceeds the size of the disk cache", entry.Key, e.FileSize)
+	}
+	// the file size is part of the header, so the header is marshalled again with the (almost exact) size
+	if header, err = json.Marshal(e); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(d.dir, diskCacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	w.Write(header)
+	w.WriteByte('\n')
+	w.Write(data)
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// first remove, to have correct size counting
+	d.lruBackend.Remove(entry.Key)
+	if err := os.Rename(tmp.Name(), d.file(entry.Key)); err != nil {
+		return err
+	}
+	d.currentSizeBytes += e.FileSize
+	d.lruBackend.Add(entry.Key, e)
+	d.removeOversize()
+	return nil
+}
+
+// Get reads the entry from disk. Entries are returned, independent of their expiry.
+func (d *DiskCache) Get(key string) (Entry, bool) {
+	d.lock.Lock()
+	e, found := d.lruBackend.Get(key)
+	d.lock.Unlock()
+	if !found {
+		return Entry{}, false
+	}
+	entry := e.(*diskEntry)
+	if time.Since(entry.StoredAt) > d.maxAge {
+		d.Remove(key)
+		return Entry{}, false
+	}
+
+	content, err := ioutil.ReadFile(d.file(key))
+	if err != nil {
+		// the entry may have been removed concurrently
+		return Entry{}, false
+	}
+	result := entry.Entry
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		result.Object, err = d.serializer.Deserialize(content[i+1:])
+	} else {
+		err = fmt.Errorf("missing header")
+	}
+	if err != nil {
+		logging.Logger.WithError(err).Warnf("error reading disk cache entry %v", key)
+		d.Remove(key)
+		return Entry{}, false
+	}
+	return result, true
+}
+
+// Contains returns true, if the entry with the key is on disk, without reading it.
+func (d *DiskCache) Contains(key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lruBackend.Contains(key)
+}
+
+// Remove deletes the entry and returns true, if it was found.
+func (d *DiskCache) Remove(key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lruBackend.Remove(key)
+}
+
+// RemoveWhere deletes all entries, which match.
+func (d *DiskCache) RemoveWhere(match func(entry Entry) bool) []PurgedEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	purged := []PurgedEntry{}
+	for _, key := range d.lruBackend.Keys() {
+		e, found := d.lruBackend.Peek(key)
+		if !found {
+			continue
+		}
+		if entry := e.(*diskEntry); match(entry.Entry) {
+			d.lruBackend.Remove(key)
+			purged = append(purged, PurgedEntry{Key: entry.Key, Label: entry.Label})
+		}
+	}
+	return purged
+}
+
+// PurgeOldEntries deletes all entries, which are older than the maxAge of the disk cache.
+func (d *DiskCache) PurgeOldEntries() {
+	d.lock.Lock()
+	keys := d.lruBackend.Keys()
+	purged := 0
+	for _, key := range keys {
+		if e, found := d.lruBackend.Peek(key); found && time.Since(e.(*diskEntry).StoredAt) > d.maxAge {
+			d.lruBackend.Remove(key)
+			purged++
+		}
+	}
+	d.lock.Unlock()
+	logging.Logger.Infof("purged %v out of %v disk cache entries", purged, len(keys))
+}
+
+// Invalidate deletes all entries.
+func (d *DiskCache) Invalidate() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.lruBackend.Purge()
+}
+
+// SizeByte returns the total size of the files
+func (d *DiskCache) SizeByte() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.currentSizeBytes
+}
+
+// Len returns the total number of entries
+func (d *DiskCache) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.lruBackend.Len()
+}
+
+// Entries returns the descriptions of all entries, starting with those, which are evicted next.
+// The objects are not read for this.
+func (d *DiskCache) Entries() []EntryInfo {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	infos := make([]EntryInfo, 0, d.lruBackend.Len())
+	for _, key := range d.lruBackend.Keys() {
+		if e, found := d.lruBackend.Peek(key); found {
+			infos = append(infos, e.(*diskEntry).info())
+		}
+	}
+	return infos
+}
+
+// Entry returns the description of the entry with the key, without reading its object.
+func (d *DiskCache) Entry(key string) (EntryInfo, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	e, found := d.lruBackend.Peek(key)
+	if !found {
+		return EntryInfo{}, false
+	}
+	return e.(*diskEntry).info(), true
+}
+
+func (entry *diskEntry) info() EntryInfo {
+	return EntryInfo{
+		Key:        entry.Key,
+		Label:      entry.Label,
+		SizeBytes:  entry.SizeBytes,
+		Age:        time.Since(entry.StoredAt).Seconds(),
+		TTL:        time.Until(entry.Expiry).Seconds(),
+		Tags:       entry.Tags,
+		ObjectType: diskObjectType,
+	}
+}
+
+// removeOversize removes the least recently used entries, until the size limit is met.
+// The method has to be called in a locked mutex block.
+func (d *DiskCache) removeOversize() {
+	for d.currentSizeBytes > d.maxSizeBytes {
+		d.lruBackend.RemoveOldest()
+	}
+}
+
+// called by the lru, if entries are removed.
+// Attention: This method does not locking, because it is a subcall of locked methods.
+func (d *DiskCache) onEvicted(key, value interface{}) {
+	entry := value.(*diskEntry)
+	d.currentSizeBytes -= entry.FileSize
+	if err := os.Remove(d.file(entry.Key)); err != nil && !os.IsNotExist(err) {
+		logging.Logger.WithError(err).Warnf("error removing disk cache entry %v", entry.Key)
+	}
+}
+
+// file returns the name of the file for the key, which may contain any characters.
+func (d *DiskCache) file(key string) string {
+	hash := md5.Sum([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(hash[:])+diskCacheFileSuffix)
+}
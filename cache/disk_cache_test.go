@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stringSerializer serializes string objects
+type stringSerializer struct{}
+
+func (s stringSerializer) Serialize(cacheObject interface{}) ([]byte, error) {
+	if str, ok := cacheObject.(string); ok {
+		return []byte(str), nil
+	}
+	return nil, errors.New("not a string")
+}
+
+func (s stringSerializer) Deserialize(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "disk-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func Test_DiskCache_SetGetRemove(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+
+	expiry := time.Now().Add(time.Minute).Round(0)
+	a.NoError(d.Set(Entry{Key: "a/b", Label: "http://a", SizeBytes: 3, Expiry: expiry, Tags: []string{"tag"}, Object: "foo\nbar"}))
+	a.Error(d.Set(Entry{Key: "b", Object: 42}))
+
+	entry, found := d.Get("a/b")
+	a.True(found)
+	a.Equal("foo\nbar", entry.Object)
+	a.Equal("http://a", entry.Label)
+	a.Equal([]string{"tag"}, entry.Tags)
+	a.True(expiry.Equal(entry.Expiry))
+	a.Equal(1, d.Len())
+	a.True(d.SizeByte() > 7)
+
+	a.True(d.Remove("a/b"))
+	_, found = d.Get("a/b")
+	a.False(found)
+	a.Equal(0, d.SizeByte())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	a.Equal(0, len(files))
+}
+
+func Test_DiskCache_Limits(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDiskCache(dir, 1, 20*time.Millisecond, stringSerializer{})
+	a.NoError(err)
+	d.maxSizeBytes = 800
+
+	// the least recently used entry is removed, if the size is exceeded
+	a.NoError(d.Set(Entry{Key: "a", Object: string(make([]byte, 200))}))
+	a.NoError(d.Set(Entry{Key: "b", Object: string(make([]byte, 200))}))
+	d.Get("a")
+	a.NoError(d.Set(Entry{Key: "c", Object: string(make([]byte, 200))}))
+	_, found := d.Get("b")
+	a.False(found)
+	_, found = d.Get("a")
+	a.True(found)
+	a.Error(d.Set(Entry{Key: "d", Object: string(make([]byte, 900))}))
+
+	// entries are removed after the maxAge
+	time.Sleep(25 * time.Millisecond)
+	_, found = d.Get("a")
+	a.False(found)
+	d.PurgeOldEntries()
+	a.Equal(0, d.Len())
+}
+
+func Test_DiskCache_ReadsExistingEntries(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	d, err := NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	a.NoError(d.Set(Entry{Key: "a", Label: "http://a", Object: "foo"}))
+	a.NoError(d.Set(Entry{Key: "b", Label: "http://b", Object: "bar"}))
+
+	// a new process finds the entries
+	d, err = NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	a.Equal(2, d.Len())
+	entry, found := d.Get("b")
+	a.True(found)
+	a.Equal("bar", entry.Object)
+
+	a.True(d.Contains("a"))
+	a.False(d.Contains("c"))
+
+	a.Equal([]PurgedEntry{{Key: "a", Label: "http://a"}}, d.RemoveWhere(func(entry Entry) bool {
+		return entry.Label == "http://a"
+	}))
+	d.Invalidate()
+	a.Equal(0, d.Len())
+}
+
+func Test_DiskCache_RemovesTempFilesOfInterruptedWrites(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, diskCacheTempPrefix+"123456")
+	a.NoError(ioutil.WriteFile(tmp, []byte("interrupted"), 0644))
+
+	d, err := NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	a.Equal(0, d.Len())
+	_, err = os.Stat(tmp)
+	a.True(os.IsNotExist(err))
+}
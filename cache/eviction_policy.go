@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// Backend stores the entries of a Cache and decides, which entry is evicted next.
+// The eviction callback has to be called for each entry, which is removed by
+// Add, Remove, RemoveOldest and Purge, but not if the value of an existing key is replaced by Add.
+type Backend interface {
+	// Add adds or replaces the value of the key and returns true, if an entry was evicted for it.
+	Add(key, value interface{}) bool
+
+	// Get returns the value of the key and counts the access.
+	Get(key interface{}) (value interface{}, ok bool)
+
+	// Contains checks, if the key is in the backend, without counting an access.
+	Contains(key interface{}) bool
+
+	// Peek returns the value of the key, without counting an access.
+	Peek(key interface{}) (value interface{}, ok bool)
+
+	// Remove removes the key and returns true, if it was found.
+	Remove(key interface{}) bool
+
+	// RemoveOldest removes the entry, which would be evicted next.
+	RemoveOldest() (key, value interface{}, ok bool)
+
+	// Keys returns the keys, starting with those, which are evicted next.
+	Keys() []interface{}
+
+	// Len returns the number of entries.
+	Len() int
+
+	// Purge removes all entries.
+	Purge()
+}
+
+// EvictionPolicy creates the Backend of a Cache with the maximum number of entries.
+type EvictionPolicy func(maxEntries int, onEvicted simplelru.EvictCallback) (Backend, error)
+
+// LRUPolicy evicts the least recently used entries. This is the default of NewCache().
+func LRUPolicy(maxEntries int, onEvicted simplelru.EvictCallback) (Backend, error) {
+	return simplelru.NewLRU(maxEntries, onEvicted)
+}
+
+// LFUPolicy evicts the least frequently used entries, and of them the least recently used.
+// The frequencies are halved periodically, so that formerly frequently used entries age out.
+func LFUPolicy(maxEntries int, onEvicted simplelru.EvictCallback) (Backend, error) {
+	return newLFU(maxEntries, onEvicted)
+}
+
+// TwoQueuePolicy is the scan resistant 2Q algorithm. New entries are evicted first, so that a scan
+// of many entries, which are accessed only once, does not evict the entries, which are accessed frequently.
+func TwoQueuePolicy(maxEntries int, onEvicted simplelru.EvictCallback) (Backend, error) {
+	return newTwoQueue(maxEntries, onEvicted)
+}
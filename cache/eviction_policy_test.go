@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var evictionPolicies = map[string]EvictionPolicy{
+	"lru": LRUPolicy,
+	"lfu": LFUPolicy,
+	"2q":  TwoQueuePolicy,
+}
+
+func Test_EvictionPolicy_SizeAndHits(t *testing.T) {
+	for name, policy := range evictionPolicies {
+		t.Run(name, func(t *testing.T) {
+			a := assert.New(t)
+
+			c := NewCacheWithPolicy("my-cache", 3, 1, time.Hour, policy)
+			c.Set("a", "", 400*1024, "a", time.Time{})
+			c.Set("a", "", 300*1024, "a", time.Time{})
+			c.Set("b", "", 300*1024, "b", time.Time{})
+			a.Equal(600*1024, c.SizeByte())
+
+			c.Get("a")
+			c.Get("a")
+			entry, _ := c.Entry("a")
+			a.Equal(2, entry.Hits)
+
+			// evicted by size
+			c.Set("c", "", 500*1024, "c", time.Time{})
+			a.Equal(2, c.Len())
+			a.Equal(800*1024, c.SizeByte())
+
+			// evicted by the number of entries
+			c.Set("d", "", 1, "d", time.Time{})
+			c.Set("e", "", 1, "e", time.Time{})
+			a.Equal(3, c.Len())
+			a.True(c.SizeByte() <= 1024*1024)
+
+			a.True(c.Remove("e"))
+			c.PurgeByLabel("")
+			a.Equal(0, c.Len())
+			a.Equal(0, c.SizeByte())
+
+			c.Set("f", "", 42, "f", time.Time{})
+			c.Invalidate()
+			a.Equal(0, c.Len())
+			a.Equal(0, c.SizeByte())
+			a.Equal(2, c.Stats().Evictions)
+		})
+	}
+}
+
+func Test_EvictionPolicy_LFU(t *testing.T) {
+	a := assert.New(t)
+
+	evicted := []interface{}{}
+	l, err := newLFU(3, func(key, value interface{}) { evicted = append(evicted, key) })
+	a.NoError(err)
+	l.Add("a", 1)
+	l.Add("b", 2)
+	l.Add("c", 3)
+	l.Get("a")
+	l.Get("a")
+	l.Get("c")
+	a.Equal([]interface{}{"b", "c", "a"}, l.Keys())
+
+	// the least frequently used is evicted
+	a.True(l.Add("d", 4))
+	a.Equal([]interface{}{"b"}, evicted)
+
+	// of the same frequency, the least recently added is evicted
+	key, _, _ := l.RemoveOldest()
+	a.Equal("d", key)
+	key, _, _ = l.RemoveOldest()
+	a.Equal("c", key)
+
+	// replacing a value counts as access, but is no eviction
+	a.False(l.Add("a", 5))
+	v, _ := l.Peek("a")
+	a.Equal(5, v)
+	a.Equal([]interface{}{"b", "d", "c"}, evicted)
+}
+
+func Test_EvictionPolicy_TwoQueue_ScanResistance(t *testing.T) {
+	a := assert.New(t)
+
+	for name, policy := range map[string]EvictionPolicy{"lru": LRUPolicy, "2q": TwoQueuePolicy} {
+		c := NewCacheWithPolicy("my-cache", 100, 100, time.Hour, policy)
+
+		// the hot entries are used frequently
+		for i := 0; i < 20; i++ {
+			c.Set(fmt.Sprintf("layout%v", i), "", 1, i, time.Time{})
+			c.Get(fmt.Sprintf("layout%v", i))
+		}
+
+		// a scan of many entries, which are used only once
+		for i := 0; i < 1000; i++ {
+			c.Set(fmt.Sprintf("product%v", i), "", 1, i, time.Time{})
+		}
+
+		_, found := c.Get("layout0")
+		a.Equal(name == "2q", found, name)
+		a.Equal(100, c.Len())
+	}
+}
+
+func Test_EvictionPolicy_TwoQueue_Ghost(t *testing.T) {
+	a := assert.New(t)
+
+	q, err := newTwoQueue(4, nil)
+	a.NoError(err)
+	q.Add("a", 1)
+	q.Add("b", 2)
+	q.Get("b")
+	key, _, _ := q.RemoveOldest()
+	a.Equal("a", key)
+
+	// an evicted recent entry, which is added again, is frequent
+	q.Add("a", 1)
+	a.True(q.frequent.Contains("a"))
+
+	a.True(q.Remove("a"))
+	a.False(q.Remove("a"))
+	a.Equal([]interface{}{"b"}, q.Keys())
+}
+
+func Test_EvictionPolicy_LFU_Decay(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := newLFU(10, nil)
+	a.NoError(err)
+
+	// the old workload uses some keys very frequently
+	for i := 0; i < 5; i++ {
+		l.Add(fmt.Sprintf("old%v", i), i)
+		for j := 0; j < 2000; j++ {
+			l.Get(fmt.Sprintf("old%v", i))
+		}
+	}
+
+	// the new workload uses other keys, together with some keys, which are used only once
+	for round := 0; round < 200; round++ {
+		for i := 0; i < 5; i++ {
+			key := fmt.Sprintf("new%v", i)
+			if _, found := l.Get(key); !found {
+				l.Add(key, i)
+			}
+		}
+		l.Add(fmt.Sprintf("once%v", round), round)
+	}
+
+	// then the formerly hot keys are evicted
+	for i := 0; i < 5; i++ {
+		a.False(l.Contains(fmt.Sprintf("old%v", i)))
+		a.True(l.Contains(fmt.Sprintf("new%v", i)))
+	}
+	a.Equal(10, l.Len())
+}
+
+func Test_EvictionPolicy_LFU_MaxFrequency(t *testing.T) {
+	a := assert.New(t)
+
+	l, err := newLFU(1000000, nil)
+	a.NoError(err)
+	l.Add("a", 1)
+	l.Add("b", 2)
+	for i := 0; i < 2*lfuMaxFrequency; i++ {
+		l.Get("a")
+		l.Get("b")
+	}
+	a.Equal(1, l.buckets.Len())
+	a.Equal(lfuMaxFrequency, l.buckets.Front().Value.(*lfuBucket).frequency)
+
+	// within the maximum frequency, the least recently used is evicted first
+	l.Get("a")
+	a.Equal([]interface{}{"b", "a"}, l.Keys())
+}
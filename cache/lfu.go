@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	// lfuMaxFrequency is the upper limit of the frequency of an entry
+	lfuMaxFrequency = 1024
+
+	// lfuDecayFactor is the number of accesses relative to the size, after which all frequencies are halved
+	lfuDecayFactor = 10
+)
+
+// lfu is a Backend, which evicts the least frequently used entries.
+// The entries are kept in buckets of the same frequency, which are ordered by their frequency,
+// so that all operations are O(1). Within a bucket, the entries are ordered from the least to the most recently used.
+// The frequencies are limited and halved periodically, so that entries, which were used frequently
+// in the past, are evicted, if they are not used anymore.
+type lfu struct {
+	size          int
+	items         map[interface{}]*list.Element // the elements of the entries in their bucket
+	buckets       *list.List                    // the buckets in ascending order of their frequency
+	accesses      int                           // the accesses since the last decay
+	decayInterval int
+	onEvicted     simplelru.EvictCallback
+}
+
+type lfuBucket struct {
+	frequency int
+	entries   *list.List
+}
+
+type lfuItem struct {
+	key    interface{}
+	value  interface{}
+	bucket *list.Element
+}
+
+func newLFU(size int, onEvicted simplelru.EvictCallback) (*lfu, error) {
+	if size <= 0 {
+		return nil, errors.New("Must provide a positive size")
+	}
+	return &lfu{
+		size:          size,
+		items:         map[interface{}]*list.Element{},
+		buckets:       list.New(),
+		decayInterval: lfuDecayFactor * size,
+		onEvicted:     onEvicted,
+	}, nil
+}
+
+func (l *lfu) Add(key, value interface{}) bool {
+	if element, found := l.items[key]; found {
+		element.Value.(*lfuItem).value = value
+		l.increment(element)
+		return false
+	}
+
+	evicted := false
+	if len(l.items) >= l.size {
+		_, _, evicted = l.RemoveOldest()
+	}
+	first := l.buckets.Front()
+	if first == nil || first.Value.(*lfuBucket).frequency != 1 {
+		first = l.buckets.PushFront(&lfuBucket{frequency: 1, entries: list.New()})
+	}
+	l.items[key] = first.Value.(*lfuBucket).entries.PushBack(&lfuItem{key: key, value: value, bucket: first})
+	return evicted
+}
+
+func (l *lfu) Get(key interface{}) (interface{}, bool) {
+	element, found := l.items[key]
+	if !found {
+		return nil, false
+	}
+	value := element.Value.(*lfuItem).value
+	l.increment(element)
+	return value, true
+}
+
+func (l *lfu) Contains(key interface{}) bool {
+	_, found := l.items[key]
+	return found
+}
+
+func (l *lfu) Peek(key interface{}) (interface{}, bool) {
+	element, found := l.items[key]
+	if !found {
+		return nil, false
+	}
+	return element.Value.(*lfuItem).value, true
+}
+
+func (l *lfu) Remove(key interface{}) bool {
+	element, found := l.items[key]
+	if found {
+		l.remove(element)
+	}
+	return found
+}
+
+func (l *lfu) RemoveOldest() (interface{}, interface{}, bool) {
+	first := l.buckets.Front()
+	if first == nil {
+		return nil, nil, false
+	}
+	element := first.Value.(*lfuBucket).entries.Front()
+	item := element.Value.(*lfuItem)
+	l.remove(element)
+	return item.key, item.value, true
+}
+
+func (l *lfu) Keys() []interface{} {
+	keys := make([]interface{}, 0, len(l.items))
+	for bucket := l.buckets.Front(); bucket != nil; bucket = bucket.Next() {
+		for element := bucket.Value.(*lfuBucket).entries.Front(); element != nil; element = element.Next() {
+			keys = append(keys, element.Value.(*lfuItem).key)
+		}
+	}
+	return keys
+}
+
+func (l *lfu) Len() int {
+	return len(l.items)
+}
+
+func (l *lfu) Purge() {
+	for _, element := range l.items {
+		if l.onEvicted != nil {
+			item := element.Value.(*lfuItem)
+			l.onEvicted(item.key, item.value)
+		}
+	}
+	l.items = map[interface{}]*list.Element{}
+	l.buckets.Init()
+	l.accesses = 0
+}
+
+// increment moves the entry to the bucket of the next frequency, or to the end of its bucket,
+// if it has the maximum frequency.
+func (l *lfu) increment(element *list.Element) {
+	item := element.Value.(*lfuItem)
+	bucket := item.bucket
+	frequency := bucket.Value.(*lfuBucket).frequency
+
+	if frequency >= lfuMaxFrequency {
+		bucket.Value.(*lfuBucket).entries.MoveToBack(element)
+	} else {
+		target := bucket.Next()
+		if target == nil || target.Value.(*lfuBucket).frequency != frequency+1 {
+			target = l.buckets.InsertAfter(&lfuBucket{frequency: frequency + 1, entries: list.New()}, bucket)
+		}
+		l.unlink(element)
+		item.bucket = target
+		l.items[item.key] = target.Value.(*lfuBucket).entries.PushBack(item)
+	}
+
+	if l.accesses++; l.accesses >= l.decayInterval {
+		l.decay()
+	}
+}
+
+// decay halves the frequencies of all entries. Buckets, which get the same frequency, are merged,
+// with the entries of the formerly lower frequency first.
+func (l *lfu) decay() {
+	l.accesses = 0
+	buckets := list.New()
+	for bucket := l.buckets.Front(); bucket != nil; bucket = bucket.Next() {
+		frequency := bucket.Value.(*lfuBucket).frequency / 2
+		if frequency < 1 {
+			frequency = 1
+		}
+		target := buckets.Back()
+		if target == nil || target.Value.(*lfuBucket).frequency != frequency {
+			target = buckets.PushBack(&lfuBucket{frequency: frequency, entries: list.New()})
+		}
+		for element := bucket.Value.(*lfuBucket).entries.Front(); element != nil; element = element.Next() {
+			item := element.Value.(*lfuItem)
+			item.bucket = target
+			l.items[item.key] = target.Value.(*lfuBucket).entries.PushBack(item)
+		}
+	}
+	l.buckets = buckets
+}
+
+func (l *lfu) remove(element *list.Element) {
+	item := element.Value.(*lfuItem)
+	l.unlink(element)
+	delete(l.items, item.key)
+	if l.onEvicted != nil {
+		l.onEvicted(item.key, item.value)
+	}
+}
+
+// unlink removes the element from its bucket and removes the bucket, if it is empty.
+func (l *lfu) unlink(element *list.Element) {
+	bucket := element.Value.(*lfuItem).bucket
+	entries := bucket.Value.(*lfuBucket).entries
+	entries.Remove(element)
+	if entries.Len() == 0 {
+		l.buckets.Remove(bucket)
+	}
+}
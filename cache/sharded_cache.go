@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+)
+
+// sizeBudget is a size limit, which is shared by multiple caches.
+type sizeBudget struct {
+	currentSizeBytes int64 // first field, to be 64 bit aligned for atomic access
+	maxSizeBytes     int64
+}
+
+func (b *sizeBudget) oversize() bool {
+	return atomic.LoadInt64(&b.currentSizeBytes) > b.maxSizeBytes
+}
+
+// ShardedCache distributes its entries by the hash of their keys over independent Cache shards,
+// so that concurrent accesses to different keys do not compete for one lock.
+// The shards share the size limit of the cache, while the maximum number of entries is divided between them.
+// If the size limit is exceeded, the entries of the shard of the new entry are evicted first,
+// and, if this is not enough, those of the other shards.
+type ShardedCache struct {
+	name   string
+	shards []*Cache
+	budget *sizeBudget
+	next   uint32 // the shard to evict next from, if the shard of a new entry has no more entries
+}
+
+// NewShardedCache creates a new cache with the number of shards, which evict the least recently used entries
+func NewShardedCache(name string, shards int, maxEntries int, maxSizeMB int, maxAge time.Duration) *ShardedCache {
+	return NewShardedCacheWithPolicy(name, shards, maxEntries, maxSizeMB, maxAge, LRUPolicy)
+}
+
+// NewShardedCacheWithPolicy creates a new cache with the number of shards, which evict the entries by the policy
+func NewShardedCacheWithPolicy(name string, shards int, maxEntries int, maxSizeMB int, maxAge time.Duration, policy EvictionPolicy) *ShardedCache {
+	if shards < 1 {
+		panic(fmt.Sprintf("invalid number of shards: %v", shards))
+	}
+	sc := &ShardedCache{
+		name:   name,
+		shards: make([]*Cache, shards),
+		budget: &sizeBudget{maxSizeBytes: int64(maxSizeMB) * 1024 * 1024},
+	}
+	maxEntriesPerShard := (maxEntries + shards - 1) / shards
+	for i := range sc.shards {
+		sc.shards[i] = NewCacheWithPolicy(fmt.Sprintf("%v-%v", name, i), maxEntriesPerShard, maxSizeMB, maxAge, policy)
+		sc.shards[i].budget = sc.budget
+	}
+	return sc
+}
+
+// Shards returns the shards of the cache.
+func (sc *ShardedCache) Shards() []*Cache {
+	return sc.shards
+}
+
+func (sc *ShardedCache) shard(key string) *Cache {
+	return sc.shards[sc.shardIndex(key)]
+}
+
+func (sc *ShardedCache) shardIndex(key string) int {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(key))
+	return int(hasher.Sum32() % uint32(len(sc.shards)))
+}
+
+// LogEvery Start a Goroutine, which purges old entries and logs the statistics of each shard periodically.
+func (sc *ShardedCache) LogEvery(d time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(d):
+				for _, shard := range sc.shards {
+					shard.PurgeOldEntries()
+					shard.calculateStats(d)
+				}
+			}
+		}
+	}()
+}
+
+func (sc *ShardedCache) Get(key string) (interface{}, bool) {
+	return sc.shard(key).Get(key)
+}
+
+// GetStale returns the entry, even if it is out of its ttl.
+func (sc *ShardedCache) GetStale(key string) (cacheObject interface{}, staleFor time.Duration, found bool) {
+	return sc.shard(key).GetStale(key)
+}
+
+// Set puts the object into the shard of the key. Entries larger than the size limit are not stored.
+func (sc *ShardedCache) Set(key string, label string, sizeBytes int, cacheObject interface{}, expiry time.Time, tags ...string) {
+	i := sc.shardIndex(key)
+	if int64(sizeBytes) > sc.budget.maxSizeBytes {
+		sc.shards[i].Remove(key)
+		return
+	}
+	sc.shards[i].Set(key, label, sizeBytes, cacheObject, expiry, tags...)
+
+	// the shard of the entry has no more entries to evict, so the other shards have to make room
+	for sc.budget.oversize() {
+		if !sc.evictFromOtherShard(i) {
+			return
+		}
+	}
+}
+
+// evictFromOtherShard evicts an entry of the next shard with entries, except the skipped one.
+func (sc *ShardedCache) evictFromOtherShard(skip int) bool {
+	for range sc.shards {
+		i := int(atomic.AddUint32(&sc.next, 1) % uint32(len(sc.shards)))
+		if i != skip && sc.shards[i].removeOldest() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStaleRetention sets the stale retention of all shards (see Cache.SetStaleRetention()).
+func (sc *ShardedCache) SetStaleRetention(staleRetention time.Duration) {
+	for _, shard := range sc.shards {
+		shard.SetStaleRetention(staleRetention)
+	}
+}
+
+// PurgeOldEntries removes all entries which are out of their ttl and stale retention, one shard after the other.
+func (sc *ShardedCache) PurgeOldEntries() {
+	for _, shard := range sc.shards {
+		shard.PurgeOldEntries()
+	}
+}
+
+// Purge Entries with a specific hash
+func (sc *ShardedCache) PurgeEntries(keys []string) {
+	keysByShard := make([][]string, len(sc.shards))
+	for _, key := range keys {
+		i := sc.shardIndex(key)
+		keysByShard[i] = append(keysByShard[i], key)
+	}
+	for i, shardKeys := range keysByShard {
+		if len(shardKeys) > 0 {
+			sc.shards[i].PurgeEntries(shardKeys)
+		}
+	}
+}
+
+// PurgeByLabel removes all entries with the label, e.g. the url.
+func (sc *ShardedCache) PurgeByLabel(label string) []PurgedEntry {
+	return sc.purgeWhere(func(shard *Cache) []PurgedEntry {
+		return shard.PurgeByLabel(label)
+	})
+}
+
+// PurgeByLabelPrefix removes all entries with a label, which starts with the prefix.
+func (sc *ShardedCache) PurgeByLabelPrefix(prefix string) []PurgedEntry {
+	return sc.purgeWhere(func(shard *Cache) []PurgedEntry {
+		return shard.PurgeByLabelPrefix(prefix)
+	})
+}
+
+// PurgeByTag removes all entries, which were set with the tag.
+func (sc *ShardedCache) PurgeByTag(tag string) []PurgedEntry {
+	return sc.purgeWhere(func(shard *Cache) []PurgedEntry {
+		return shard.PurgeByTag(tag)
+	})
+}
+
+func (sc *ShardedCache) purgeWhere(purge func(shard *Cache) []PurgedEntry) []PurgedEntry {
+	purged := []PurgedEntry{}
+	for _, shard := range sc.shards {
+		purged = append(purged, purge(shard)...)
+	}
+	return purged
+}
+
+func (sc *ShardedCache) Invalidate() {
+	for _, shard := range sc.shards {
+		shard.Invalidate()
+	}
+}
+
+// SizeByte returns the total memory consumption of the cache
+func (sc *ShardedCache) SizeByte() int {
+	return int(atomic.LoadInt64(&sc.budget.currentSizeBytes))
+}
+
+// Len returns the total number of entries in the cache
+func (sc *ShardedCache) Len() int {
+	entries := 0
+	for _, shard := range sc.shards {
+		entries += shard.Len()
+	}
+	return entries
+}
+
+// Stats returns the statistics of all shards together.
+func (sc *ShardedCache) Stats() Stats {
+	stats := Stats{Name: sc.name, MaxSizeBytes: int(sc.budget.maxSizeBytes), HitRatio: 100}
+	for _, shard := range sc.shards {
+		shardStats := shard.Stats()
+		stats.Entries += shardStats.Entries
+		stats.MaxEntries += shardStats.MaxEntries
+		stats.SizeBytes += shardStats.SizeBytes
+		stats.MaxAge = shardStats.MaxAge
+		stats.Hits += shardStats.Hits
+		stats.Misses += shardStats.Misses
+		stats.Evictions += shardStats.Evictions
+	}
+	if stats.Hits+stats.Misses != 0 {
+		stats.HitRatio = 100 * stats.Hits / (stats.Hits + stats.Misses)
+	}
+	return stats
+}
+
+// Entries returns the descriptions of all entries, shard by shard.
+func (sc *ShardedCache) Entries() []EntryInfo {
+	entries := []EntryInfo{}
+	for _, shard := range sc.shards {
+		entries = append(entries, shard.Entries()...)
+	}
+	return entries
+}
+
+// Entry returns the description of the entry with the key.
+func (sc *ShardedCache) Entry(key string) (EntryInfo, bool) {
+	return sc.shard(key).Entry(key)
+}
+
+// Remove removes the entry with the key and returns true, if it was found.
+func (sc *ShardedCache) Remove(key string) bool {
+	return sc.shard(key).Remove(key)
+}
+
+// LiveEntries returns the entries, which are not expired, shard by shard.
+func (sc *ShardedCache) LiveEntries() []Entry {
+	entries := []Entry{}
+	for _, shard := range sc.shards {
+		entries = append(entries, shard.LiveEntries()...)
+	}
+	return entries
+}
+
+// Restore puts the entry into the cache like Set(), if it is not expired, not in the cache, yet
+// and not larger than the size of the cache.
+func (sc *ShardedCache) Restore(entry Entry) bool {
+	if !time.Now().Before(entry.Expiry) || int64(entry.SizeBytes) > sc.budget.maxSizeBytes {
+		return false
+	}
+	if _, exists := sc.shard(entry.Key).Entry(entry.Key); exists {
+		return false
+	}
+	sc.Set(entry.Key, entry.Label, entry.SizeBytes, entry.Object, entry.Expiry, entry.Tags...)
+	return true
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_ShardedCache_GetSetPurge(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewShardedCache("my-cache", 4, 100, 100, time.Hour)
+	a.Equal(4, len(c.Shards()))
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("key%v", i), fmt.Sprintf("http://example.de/%v", i), 1, i, time.Time{}, fmt.Sprintf("tag%v", i%2))
+	}
+	a.Equal(10, c.Len())
+	a.Equal(10, c.SizeByte())
+
+	v, found := c.Get("key3")
+	a.True(found)
+	a.Equal(3, v)
+	_, found = c.Get("foo")
+	a.False(found)
+
+	a.Equal(5, len(c.PurgeByTag("tag0")))
+	a.Equal(1, len(c.PurgeByLabel("http://example.de/1")))
+	c.PurgeEntries([]string{"key9"})
+	a.Equal(3, len(c.PurgeByLabelPrefix("http://example.de/")))
+	a.Equal(0, c.Len())
+	a.Equal(0, c.SizeByte())
+
+	stats := c.Stats()
+	a.Equal("my-cache", stats.Name)
+	a.Equal(100, stats.MaxEntries)
+	a.Equal(1, stats.Hits)
+	a.Equal(1, stats.Misses)
+	a.Equal(50, stats.HitRatio)
+}
+
+func Test_ShardedCache_SharedSizeBudget(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache with 1 mega byte in 4 shards
+	c := NewShardedCache("my-cache", 4, 100, 1, time.Hour)
+	for i := 0; i < 4; i++ {
+		c.Set(fmt.Sprintf("key%v", i), "", 200*1024, i, time.Time{})
+	}
+
+	// then an entry may be larger than a quarter of the size
+	c.Set("large", "", 600*1024, "large", time.Time{})
+	a.True(c.SizeByte() <= 1024*1024)
+	_, found := c.Get("large")
+	a.True(found)
+	a.Equal(c.SizeByte(), c.Stats().SizeBytes)
+	a.True(c.Stats().Evictions >= 2)
+
+	// but entries larger than the cache are not stored
+	c.Set("large", "", 2*1024*1024, "too large", time.Time{})
+	_, found = c.Get("large")
+	a.False(found)
+
+	c.Invalidate()
+	a.Equal(0, c.SizeByte())
+	a.Equal(0, c.Len())
+}
+
+func Test_ShardedCache_RestoreWithinSizeBudget(t *testing.T) {
+	a := assert.New(t)
+
+	// given a cache with 1 mega byte in 4 shards
+	c := NewShardedCache("my-cache", 4, 100, 1, time.Hour)
+	expiry := time.Now().Add(time.Hour)
+
+	// a snapshot larger than the cache
+	for i := 0; i < 8; i++ {
+		a.True(c.Restore(Entry{Key: fmt.Sprintf("key%v", i), SizeBytes: 200 * 1024, Object: i, Expiry: expiry}))
+	}
+	a.False(c.Restore(Entry{Key: "too large", SizeBytes: 2 * 1024 * 1024, Object: "too large", Expiry: expiry}))
+
+	// then the entries are evicted over all shards to keep the size
+	a.True(c.SizeByte() <= 1024*1024)
+	a.True(c.Stats().Evictions >= 3)
+	_, found := c.Get("key7")
+	a.True(found)
+	_, found = c.Get("too large")
+	a.False(found)
+}
+
+func Test_ShardedCache_ExpiryAndStale(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewShardedCache("my-cache", 2, 100, 100, time.Hour)
+	c.SetStaleRetention(time.Hour)
+	c.Set("a", "", 1, "a", time.Now().Add(time.Millisecond))
+	c.Set("b", "", 1, "b", time.Time{})
+	time.Sleep(2 * time.Millisecond)
+
+	_, found := c.Get("a")
+	a.False(found)
+	v, staleFor, found := c.GetStale("a")
+	a.True(found)
+	a.Equal("a", v)
+	a.True(staleFor > 0)
+
+	c.SetStaleRetention(0)
+	c.PurgeOldEntries()
+	a.Equal(1, c.Len())
+}
+
+func Test_ShardedCache_Concurrent(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewShardedCache("my-cache", 8, 1000, 1, time.Hour)
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key%v", (g*500+i)%700)
+				c.Set(key, "", 4*1024, i, time.Time{})
+				c.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	a.True(c.SizeByte() <= 1024*1024)
+	a.Equal(4*1024*c.Len(), c.SizeByte())
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/tarent/go-log-middleware/v2/logging"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format.
+// Snapshots of other versions are not loaded.
+const SnapshotVersion = 1
+
+// snapshotHeader is the first JSON value of a snapshot.
+type snapshotHeader struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Entries int       `json:"entries"`
+}
+
+// snapshotEntry is an entry of a snapshot, with its serialized object.
+type snapshotEntry struct {
+	Entry
+	Data []byte `json:"data"`
+}
+
+// InspectableCache is a cache, which can be inspected by the CacheHandler and written to and read from snapshots,
+// like Cache, ShardedCache and TwoTierCache.
+type InspectableCache interface {
+	// Stats returns the statistics of the cache.
+	Stats() Stats
+
+	// Entries returns the descriptions of all entries.
+	Entries() []EntryInfo
+
+	// Entry returns the description of the entry with the key.
+	Entry(key string) (EntryInfo, bool)
+
+	// Remove removes the entry with the key and returns true, if it was found.
+	Remove(key string) bool
+
+	// LiveEntries returns the entries, which are not expired, with their objects, as source for a snapshot.
+	LiveEntries() []Entry
+
+	// Restore puts an entry of a snapshot into the cache, if it is not expired and not in the cache, yet.
+	// It returns true, if the entry was added.
+	Restore(entry Entry) bool
+}
+
+// WriteSnapshot writes the live entries of the cache with the serializer to w.
+// Entries, which can not be serialized, are skipped. It returns the number of written entries.
+func WriteSnapshot(w io.Writer, c InspectableCache, serializer Serializer) (int, error) {
+	now := time.Now()
+	entries := c.LiveEntries()
+
+	records := make([]snapshotEntry, 0, len(entries))
+	for _, entry := range entries {
+		data, err := serializer.Serialize(entry.Object)
+		if err != nil {
+			logging.Logger.WithError(err).Debugf("cache entry %v not written to snapshot", entry.Key)
+			continue
+		}
+		records = append(records, snapshotEntry{Entry: entry, Data: data})
+	}
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(snapshotHeader{Version: SnapshotVersion, Name: c.Stats().Name, Created: now, Entries: len(records)}); err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return 0, err
+		}
+	}
+	return len(records), nil
+}
+
+// ReadSnapshot puts the entries of a snapshot from r into the cache, with their remaining ttl.
+// The snapshot is read completely before, so that the cache is not changed,
+// if the snapshot is corrupted or of another version. Entries, which expired in the meantime
+// or are already in the cache, are skipped. It returns the number of loaded entries.
+func ReadSnapshot(r io.Reader, c InspectableCache, serializer Serializer) (int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	header := snapshotHeader{}
+	if err := decoder.Decode(&header); err != nil {
+		return 0, fmt.Errorf("error reading snapshot header: %v", err)
+	}
+	if header.Version != SnapshotVersion {
+		return 0, fmt.Errorf("snapshot version %v is not supported, expected %v", header.Version, SnapshotVersion)
+	}
+
+	entries := make([]Entry, 0, header.Entries)
+	for i := 0; i < header.Entries; i++ {
+		record := snapshotEntry{}
+		if err := decoder.Decode(&record); err != nil {
+			return 0, fmt.Errorf("error reading snapshot entry %v of %v: %v", i+1, header.Entries, err)
+		}
+		object, err := serializer.Deserialize(record.Data)
+		if err != nil {
+			return 0, fmt.Errorf("error reading snapshot entry %v: %v", record.Key, err)
+		}
+		record.Entry.Object = object
+		entries = append(entries, record.Entry)
+	}
+	if decoder.More() {
+		return 0, fmt.Errorf("snapshot has more than %v entries", header.Entries)
+	}
+
+	loaded := 0
+	for _, entry := range entries {
+		if c.Restore(entry) {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+// SaveSnapshot writes a snapshot of the cache to the file, e.g. on shutdown.
+// The file is replaced atomically, so that an existing snapshot is kept on errors.
+// It returns the number of written entries.
+func SaveSnapshot(file string, c InspectableCache, serializer Serializer) (int, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	written, err := WriteSnapshot(w, c, serializer)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return 0, err
+	}
+	logging.Logger.Infof("wrote snapshot of %v cache entries to %v", written, file)
+	return written, nil
+}
+
+// LoadSnapshot reads a snapshot from the file into the cache, e.g. on startup.
+// A missing file is not an error. Corrupted snapshots and snapshots of other versions are ignored,
+// which is logged and returned as error. It returns the number of loaded entries.
+func LoadSnapshot(file string, c InspectableCache, serializer Serializer) (int, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	loaded, err := ReadSnapshot(f, c, serializer)
+	if err != nil {
+		logging.Logger.WithError(err).Warnf("ignoring cache snapshot %v", file)
+		return 0, err
+	}
+	logging.Logger.Infof("loaded %v cache entries from snapshot %v", loaded, file)
+	return loaded, nil
+}
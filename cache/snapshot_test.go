@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Cache_Snapshot(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 100, 100, time.Hour)
+	c.Set("a", "http://a", 1, "a", time.Now().Add(time.Minute), "tag")
+	c.Set("b", "http://b", 2, "b", time.Now().Add(time.Millisecond))
+	c.Set("c", "http://c", 3, "c", time.Time{})
+	c.Set("d", "http://d", 4, 42, time.Time{}) // not serializable
+	time.Sleep(2 * time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	written, err := WriteSnapshot(buf, c, stringSerializer{})
+	a.NoError(err)
+	a.Equal(2, written)
+
+	loaded := NewCache("my-cache", 100, 100, time.Hour)
+	loaded.Set("c", "http://c", 3, "newer", time.Time{})
+	n, err := ReadSnapshot(bytes.NewReader(buf.Bytes()), loaded, stringSerializer{})
+	a.NoError(err)
+	a.Equal(1, n)
+
+	entry, found := loaded.Entry("a")
+	a.True(found)
+	a.Equal("http://a", entry.Label)
+	a.Equal([]string{"tag"}, entry.Tags)
+	a.InDelta(60, entry.TTL, 1)
+	v, _ := loaded.Get("c")
+	a.Equal("newer", v)
+	a.Equal(4, loaded.SizeByte())
+}
+
+func Test_Cache_Snapshot_IgnoresInvalid(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewCache("my-cache", 100, 100, time.Hour)
+	c.Set("a", "http://a", 1, "a", time.Time{})
+	c.Set("b", "http://b", 1, "b", time.Time{})
+	buf := &bytes.Buffer{}
+	_, err := WriteSnapshot(buf, c, stringSerializer{})
+	a.NoError(err)
+	snapshot := buf.String()
+
+	invalid := []string{
+		"",
+		"garbage",
+		strings.Replace(snapshot, `"version":1`, `"version":42`, 1),
+		snapshot[:len(snapshot)-10],
+		snapshot[:strings.LastIndex(snapshot[:len(snapshot)-1], "\n")+1],
+		snapshot + snapshot,
+	}
+	for _, s := range invalid {
+		loaded := NewCache("my-cache", 100, 100, time.Hour)
+		n, err := ReadSnapshot(strings.NewReader(s), loaded, stringSerializer{})
+		a.Error(err)
+		a.Equal(0, n)
+		a.Equal(0, loaded.Len())
+	}
+}
+
+func Test_Cache_SaveAndLoadSnapshot(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot.json")
+
+	c := NewCache("my-cache", 100, 100, time.Hour)
+
+	// a missing snapshot is not an error
+	n, err := LoadSnapshot(file, c, stringSerializer{})
+	a.NoError(err)
+	a.Equal(0, n)
+
+	c.Set("a", "http://a", 1, "a", time.Time{})
+	handler := NewCacheHandler(c).WithSnapshot(file, stringSerializer{})
+	r, _ := http.NewRequest("POST", "/internal/cache/snapshot", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	a.Equal(200, w.Code)
+	result := SnapshotResult{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &result))
+	a.Equal(SnapshotResult{File: file, Entries: 1}, result)
+
+	loaded := NewCache("my-cache", 100, 100, time.Hour)
+	n, err = LoadSnapshot(file, loaded, stringSerializer{})
+	a.NoError(err)
+	a.Equal(1, n)
+	v, found := loaded.Get("a")
+	a.True(found)
+	a.Equal("a", v)
+
+	// without a configured snapshot file, there is no snapshot route
+	w = httptest.NewRecorder()
+	NewCacheHandler(c).ServeHTTP(w, r)
+	a.Equal(404, w.Code)
+}
+
+func Test_InspectableCache_ShardedAndTwoTier(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	disk, err := NewDiskCache(filepath.Join(dir, "disk"), 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	twoTier := NewTwoTierCache(NewCache("two-tier", 1, 100, time.Hour), disk)
+	defer twoTier.Close()
+
+	caches := map[string]func() InspectableCache{
+		"sharded":  func() InspectableCache { return NewShardedCache("sharded", 4, 100, 100, time.Hour) },
+		"two-tier": func() InspectableCache { return twoTier },
+	}
+	for name, newCache := range caches {
+		t.Run(name, func(t *testing.T) {
+			c := newCache()
+			set := c.(interface {
+				Set(key string, label string, sizeBytes int, cacheObject interface{}, expiry time.Time, tags ...string)
+			}).Set
+			set("a", "http://a", 1, "a", time.Time{})
+			set("b", "http://b", 1, "b", time.Time{})
+			if tc, ok := c.(*TwoTierCache); ok {
+				waitForDemotions(t, tc)
+			}
+
+			handler := NewCacheHandler(c).WithSnapshot(filepath.Join(dir, name+".json"), stringSerializer{})
+			serve := func(method, url string) *httptest.ResponseRecorder {
+				r, _ := http.NewRequest(method, url, nil)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				return w
+			}
+
+			// both entries are listed, also the one on disk
+			w := serve("GET", "/internal/cache/entries")
+			a.Equal(200, w.Code)
+			page := EntriesPage{}
+			a.NoError(json.Unmarshal(w.Body.Bytes(), &page))
+			a.Equal(2, page.Total)
+			a.Equal(200, serve("GET", "/internal/cache/entries/a").Code)
+
+			// snapshots are written and read, the one of the two-tier cache contains the entries in memory
+			w = serve("POST", "/internal/cache/snapshot")
+			a.Equal(200, w.Code)
+			a.Equal(204, serve("DELETE", "/internal/cache/entries/b").Code)
+			a.Equal(404, serve("GET", "/internal/cache/entries/b").Code)
+			n, err := LoadSnapshot(filepath.Join(dir, name+".json"), c, stringSerializer{})
+			a.NoError(err)
+			a.True(n >= 1)
+			_, found := c.Entry("b")
+			a.True(found)
+		})
+	}
+}
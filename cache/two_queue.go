@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"errors"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	// twoQueueRecentRatio is the share of the entries, which is kept for new entries.
+	twoQueueRecentRatio = 0.25
+
+	// twoQueueGhostRatio is the number of keys of evicted new entries, relative to the size.
+	twoQueueGhostRatio = 0.5
+)
+
+// twoQueue is a Backend with the 2Q algorithm, similar to the one of golang-lru,
+// but with an eviction callback and eviction by RemoveOldest().
+// New entries are added to the recent queue. When they are accessed again, they are moved to the frequent queue.
+// Entries of the recent queue are evicted first, as long as it has more than its share of the entries.
+// The keys of the evicted recent entries are remembered in a ghost queue,
+// so that they are added to the frequent queue, if they are added again.
+type twoQueue struct {
+	size      int
+	recent    *simplelru.LRU
+	frequent  *simplelru.LRU
+	ghost     *simplelru.LRU
+	onEvicted simplelru.EvictCallback
+}
+
+func newTwoQueue(size int, onEvicted simplelru.EvictCallback) (*twoQueue, error) {
+	if size <= 0 {
+		return nil, errors.New("Must provide a positive size")
+	}
+	ghostSize := int(float64(size) * twoQueueGhostRatio)
+	if ghostSize < 1 {
+		ghostSize = 1
+	}
+
+	// the queues are limited by this backend, so their callbacks are not used
+	q := &twoQueue{size: size, onEvicted: onEvicted}
+	var err error
+	if q.recent, err = simplelru.NewLRU(size, nil); err != nil {
+		return nil, err
+	}
+	if q.frequent, err = simplelru.NewLRU(size, nil); err != nil {
+		return nil, err
+	}
+	if q.ghost, err = simplelru.NewLRU(ghostSize, nil); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *twoQueue) Add(key, value interface{}) bool {
+	if q.frequent.Contains(key) {
+		q.frequent.Add(key, value)
+		return false
+	}
+	if q.recent.Contains(key) {
+		q.recent.Remove(key)
+		q.frequent.Add(key, value)
+		return false
+	}
+
+	evicted := false
+	if q.Len() >= q.size {
+		_, _, evicted = q.RemoveOldest()
+	}
+	if q.ghost.Contains(key) {
+		q.ghost.Remove(key)
+		q.frequent.Add(key, value)
+	} else {
+		q.recent.Add(key, value)
+	}
+	return evicted
+}
+
+func (q *twoQueue) Get(key interface{}) (interface{}, bool) {
+	if value, found := q.frequent.Get(key); found {
+		return value, true
+	}
+	if value, found := q.recent.Peek(key); found {
+		q.recent.Remove(key)
+		q.frequent.Add(key, value)
+		return value, true
+	}
+	return nil, false
+}
+
+func (q *twoQueue) Contains(key interface{}) bool {
+	return q.frequent.Contains(key) || q.recent.Contains(key)
+}
+
+func (q *twoQueue) Peek(key interface{}) (interface{}, bool) {
+	if value, found := q.frequent.Peek(key); found {
+		return value, true
+	}
+	return q.recent.Peek(key)
+}
+
+func (q *twoQueue) Remove(key interface{}) bool {
+	q.ghost.Remove(key)
+	for _, queue := range []*simplelru.LRU{q.frequent, q.recent} {
+		if value, found := queue.Peek(key); found {
+			queue.Remove(key)
+			q.evicted(key, value)
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveOldest removes the oldest recent entry, if the recent queue exceeds its share of the entries,
+// or otherwise the least recently used frequent entry.
+func (q *twoQueue) RemoveOldest() (interface{}, interface{}, bool) {
+	recent := q.recent.Len()
+	if recent > 0 && (q.frequent.Len() == 0 || float64(recent) >= twoQueueRecentRatio*float64(q.Len())) {
+		key, value, _ := q.recent.RemoveOldest()
+		q.ghost.Add(key, nil)
+		q.evicted(key, value)
+		return key, value, true
+	}
+	key, value, ok := q.frequent.RemoveOldest()
+	if ok {
+		q.evicted(key, value)
+	}
+	return key, value, ok
+}
+
+// Keys returns the keys of the recent queue, followed by those of the frequent queue.
+func (q *twoQueue) Keys() []interface{} {
+	return append(q.recent.Keys(), q.frequent.Keys()...)
+}
+
+func (q *twoQueue) Len() int {
+	return q.recent.Len() + q.frequent.Len()
+}
+
+func (q *twoQueue) Purge() {
+	for _, queue := range []*simplelru.LRU{q.recent, q.frequent} {
+		for _, key := range queue.Keys() {
+			value, _ := queue.Peek(key)
+			q.evicted(key, value)
+		}
+		queue.Purge()
+	}
+	q.ghost.Purge()
+}
+
+func (q *twoQueue) evicted(key, value interface{}) {
+	if q.onEvicted != nil {
+		q.onEvicted(key, value)
+	}
+}
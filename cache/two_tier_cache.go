@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"github.com/tarent/go-log-middleware/v2/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDemotionQueueSize is the number of evicted entries, which may wait to be written to disk.
+const DefaultDemotionQueueSize = 1000
+
+// TwoTierCache combines a Cache in memory with a DiskCache as second level.
+// Entries, which are evicted from memory because of its limits, are demoted to disk,
+// and entries found on disk are promoted back to memory.
+// The demoted entries are written to disk in the background, so that a slow disk does not block Set().
+// If the queue of the writer is full, demoted entries are dropped. Until they are written,
+// the queued entries are still found by Get().
+type TwoTierCache struct {
+	memory *Cache
+	disk   *DiskCache
+
+	queue        chan demotion
+	pendingMutex sync.Mutex
+	pending      map[string]demotion // the queued entries by key
+	seq          uint64
+	dropped      int
+	closeOnce    sync.Once
+	closed       chan struct{}
+	done         chan struct{}
+}
+
+// demotion is an entry, which waits to be written to disk.
+type demotion struct {
+	entry Entry
+	seq   uint64
+}
+
+// NewTwoTierCache creates a TwoTierCache and starts the writer of the demoted entries.
+// The eviction listener of the memory cache is set by this.
+func NewTwoTierCache(memory *Cache, disk *DiskCache) *TwoTierCache {
+	return newTwoTierCache(memory, disk, DefaultDemotionQueueSize)
+}
+
+func newTwoTierCache(memory *Cache, disk *DiskCache, queueSize int) *TwoTierCache {
+	tc := &TwoTierCache{
+		memory:  memory,
+		disk:    disk,
+		queue:   make(chan demotion, queueSize),
+		pending: map[string]demotion{},
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	memory.SetEvictionListener(tc.demote)
+	go tc.writeDemotions()
+	return tc
+}
+
+// Memory returns the first level of the cache.
+func (tc *TwoTierCache) Memory() *Cache {
+	return tc.memory
+}
+
+// Disk returns the second level of the cache.
+func (tc *TwoTierCache) Disk() *DiskCache {
+	return tc.disk
+}
+
+// Dropped returns the number of demoted entries, which were dropped, because the queue of the writer was full.
+func (tc *TwoTierCache) Dropped() int {
+	tc.pendingMutex.Lock()
+	defer tc.pendingMutex.Unlock()
+	return tc.dropped
+}
+
+// Close writes the queued entries to disk and stops the writer, e.g. on shutdown.
+// Entries, which are demoted afterwards, are dropped.
+func (tc *TwoTierCache) Close() {
+	tc.closeOnce.Do(func() {
+		close(tc.closed)
+	})
+	<-tc.done
+}
+
+// LogEvery Start a Goroutine, which logs statistics and purges old entries of both tiers periodically.
+func (tc *TwoTierCache) LogEvery(d time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-time.After(d):
+				tc.PurgeOldEntries()
+				tc.memory.calculateStats(d)
+			}
+		}
+	}()
+}
+
+func (tc *TwoTierCache) Get(key string) (interface{}, bool) {
+	if cacheObject, found := tc.memory.Get(key); found {
+		return cacheObject, true
+	}
+	entry, found := tc.secondLevel(key)
+	if !found || !time.Now().Before(entry.Expiry) {
+		return nil, false
+	}
+	tc.promote(entry)
+	return entry.Object, true
+}
+
+// GetStale returns the entry out of memory or disk, even if it is out of its ttl.
+func (tc *TwoTierCache) GetStale(key string) (cacheObject interface{}, staleFor time.Duration, found bool) {
+	if cacheObject, staleFor, found = tc.memory.GetStale(key); found {
+		return cacheObject, staleFor, true
+	}
+	entry, found := tc.secondLevel(key)
+	if !found {
+		return nil, 0, false
+	}
+	tc.promote(entry)
+	return entry.Object, time.Since(entry.Expiry), true
+}
+
+// Set puts the object into memory and removes an older version from disk.
+func (tc *TwoTierCache) Set(key string, label string, sizeBytes int, cacheObject interface{}, expiry time.Time, tags ...string) {
+	tc.memory.Set(key, label, sizeBytes, cacheObject, expiry, tags...)
+	tc.removePendingKey(key)
+	if tc.disk.Contains(key) {
+		tc.disk.Remove(key)
+	}
+}
+
+func (tc *TwoTierCache) Invalidate() {
+	tc.memory.Invalidate()
+	tc.removePending(func(entry Entry) bool { return true })
+	tc.disk.Invalidate()
+}
+
+func (tc *TwoTierCache) PurgeEntries(keys []string) {
+	tc.memory.PurgeEntries(keys)
+	tc.removePending(func(entry Entry) bool { return contains(keys, entry.Key) })
+	for _, key := range keys {
+		tc.disk.Remove(key)
+	}
+}
+
+func (tc *TwoTierCache) PurgeByLabel(label string) []PurgedEntry {
+	return tc.purgeWhere(tc.memory.PurgeByLabel(label), func(entry Entry) bool {
+		return entry.Label == label
+	})
+}
+
+func (tc *TwoTierCache) PurgeByLabelPrefix(prefix string) []PurgedEntry {
+	return tc.purgeWhere(tc.memory.PurgeByLabelPrefix(prefix), func(entry Entry) bool {
+		return strings.HasPrefix(entry.Label, prefix)
+	})
+}
+
+func (tc *TwoTierCache) PurgeByTag(tag string) []PurgedEntry {
+	return tc.purgeWhere(tc.memory.PurgeByTag(tag), func(entry Entry) bool {
+		return contains(entry.Tags, tag)
+	})
+}
+
+// purgeWhere removes the matching entries from the queue and the disk, and adds them to the purged entries of memory.
+func (tc *TwoTierCache) purgeWhere(purged []PurgedEntry, match func(entry Entry) bool) []PurgedEntry {
+	purged = append(purged, tc.removePending(match)...)
+	return append(purged, tc.disk.RemoveWhere(match)...)
+}
+
+// PurgeOldEntries removes the expired entries of both tiers.
+func (tc *TwoTierCache) PurgeOldEntries() {
+	tc.memory.PurgeOldEntries()
+	tc.disk.PurgeOldEntries()
+}
+
+// Stats returns the statistics of the memory tier.
+func (tc *TwoTierCache) Stats() Stats {
+	return tc.memory.Stats()
+}
+
+// Entries returns the descriptions of the entries in memory, followed by those on disk.
+// The entries, which wait to be written to disk, are not included.
+func (tc *TwoTierCache) Entries() []EntryInfo {
+	return append(tc.memory.Entries(), tc.disk.Entries()...)
+}
+
+// Entry returns the description of the entry with the key out of memory or disk.
+func (tc *TwoTierCache) Entry(key string) (EntryInfo, bool) {
+	if info, found := tc.memory.Entry(key); found {
+		return info, true
+	}
+	return tc.disk.Entry(key)
+}
+
+// Remove removes the entry with the key from both tiers and returns true, if it was found.
+func (tc *TwoTierCache) Remove(key string) bool {
+	tc.pendingMutex.Lock()
+	_, pending := tc.pending[key]
+	delete(tc.pending, key)
+	tc.pendingMutex.Unlock()
+
+	inMemory := tc.memory.Remove(key)
+	onDisk := tc.disk.Remove(key)
+	return inMemory || pending || onDisk
+}
+
+// LiveEntries returns the live entries of the memory tier. The disk tier is persistent by itself.
+func (tc *TwoTierCache) LiveEntries() []Entry {
+	return tc.memory.LiveEntries()
+}
+
+// Restore puts the entry into memory, if it is not expired and not in the cache, yet.
+func (tc *TwoTierCache) Restore(entry Entry) bool {
+	if _, found := tc.secondLevel(entry.Key); found {
+		return false
+	}
+	return tc.memory.Restore(entry)
+}
+
+// secondLevel returns the entry out of the queue of the writer or from disk.
+func (tc *TwoTierCache) secondLevel(key string) (Entry, bool) {
+	tc.pendingMutex.Lock()
+	d, found := tc.pending[key]
+	tc.pendingMutex.Unlock()
+	if found {
+		return d.entry, true
+	}
+	return tc.disk.Get(key)
+}
+
+// demote queues an entry, which was evicted from memory, to be written to disk.
+// It does not block, so the entry is dropped, if the queue is full.
+func (tc *TwoTierCache) demote(entry Entry) {
+	tc.pendingMutex.Lock()
+	defer tc.pendingMutex.Unlock()
+
+	tc.seq++
+	d := demotion{entry: entry, seq: tc.seq}
+	select {
+	case tc.queue <- d:
+		tc.pending[entry.Key] = d
+	default:
+		tc.dropped++
+		logging.Logger.Debugf("cache entry %v not demoted to disk, because the queue is full", entry.Key)
+	}
+}
+
+// writeDemotions writes the queued entries to disk, until the cache is closed.
+func (tc *TwoTierCache) writeDemotions() {
+	defer close(tc.done)
+	for {
+		select {
+		case d := <-tc.queue:
+			tc.write(d)
+		case <-tc.closed:
+			for {
+				select {
+				case d := <-tc.queue:
+					tc.write(d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes a queued entry to disk, if it was not promoted, purged or demoted again in the meantime.
+func (tc *TwoTierCache) write(d demotion) {
+	if !tc.isPending(d) {
+		return
+	}
+	if err := tc.disk.Set(d.entry); err != nil {
+		logging.Logger.WithError(err).Debugf("cache entry %v not demoted to disk", d.entry.Key)
+	}
+
+	tc.pendingMutex.Lock()
+	current, found := tc.pending[d.entry.Key]
+	outdated := !found || current.seq != d.seq
+	if !outdated {
+		delete(tc.pending, d.entry.Key)
+	}
+	tc.pendingMutex.Unlock()
+
+	// the entry was removed while it was written, so it must not stay on disk
+	if outdated {
+		tc.disk.Remove(d.entry.Key)
+	}
+}
+
+func (tc *TwoTierCache) isPending(d demotion) bool {
+	tc.pendingMutex.Lock()
+	defer tc.pendingMutex.Unlock()
+	current, found := tc.pending[d.entry.Key]
+	return found && current.seq == d.seq
+}
+
+// removePending removes the matching entries out of the queue of the writer.
+func (tc *TwoTierCache) removePending(match func(entry Entry) bool) []PurgedEntry {
+	tc.pendingMutex.Lock()
+	defer tc.pendingMutex.Unlock()
+
+	purged := []PurgedEntry{}
+	for key, d := range tc.pending {
+		if match(d.entry) {
+			delete(tc.pending, key)
+			purged = append(purged, PurgedEntry{Key: d.entry.Key, Label: d.entry.Label})
+		}
+	}
+	return purged
+}
+
+func (tc *TwoTierCache) removePendingKey(key string) {
+	tc.pendingMutex.Lock()
+	defer tc.pendingMutex.Unlock()
+	delete(tc.pending, key)
+}
+
+// promote moves an entry from disk to memory.
+func (tc *TwoTierCache) promote(entry Entry) {
+	tc.removePendingKey(entry.Key)
+	tc.disk.Remove(entry.Key)
+	tc.memory.Set(entry.Key, entry.Label, entry.SizeBytes, entry.Object, entry.Expiry, entry.Tags...)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
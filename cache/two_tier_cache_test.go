@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitForDemotions waits, until the writer has written the queued entries.
+func waitForDemotions(t *testing.T, tc *TwoTierCache) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		tc.pendingMutex.Lock()
+		pending := len(tc.pending)
+		tc.pendingMutex.Unlock()
+		if pending == 0 {
+			return
+		}
+	}
+	t.Fatal("demoted entries were not written")
+}
+
+// slowSerializer serializes string objects, after waiting for a release
+type slowSerializer struct {
+	release chan struct{}
+}
+
+func (s slowSerializer) Serialize(cacheObject interface{}) ([]byte, error) {
+	<-s.release
+	if str, ok := cacheObject.(string); ok {
+		return []byte(str), nil
+	}
+	return nil, errors.New("not a string")
+}
+
+func (s slowSerializer) Deserialize(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+func Test_TwoTierCache_DemoteAndPromote(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	disk, err := NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	tc := NewTwoTierCache(NewCache("my-cache", 2, 100, time.Hour), disk)
+
+	tc.Set("a", "http://a", 1, "a", time.Time{}, "tag")
+	tc.Set("b", "http://b", 1, "b", time.Time{})
+	tc.Set("c", "http://c", 1, "c", time.Time{})
+
+	// a is evicted from memory to disk
+	waitForDemotions(t, tc)
+	a.Equal(2, tc.Memory().Len())
+	a.Equal(1, tc.Disk().Len())
+
+	// and promoted back on access, which demotes b
+	v, found := tc.Get("a")
+	a.True(found)
+	a.Equal("a", v)
+	_, found = tc.Memory().Get("a")
+	a.True(found)
+	waitForDemotions(t, tc)
+	_, found = tc.Disk().Get("b")
+	a.True(found)
+
+	// purges cover both tiers
+	tc.Set("d", "http://d", 1, "d", time.Time{}, "tag")
+	a.Equal(2, len(tc.PurgeByTag("tag")))
+	_, found = tc.Get("a")
+	a.False(found)
+
+	tc.Invalidate()
+	a.Equal(0, tc.Memory().Len())
+	a.Equal(0, tc.Disk().Len())
+}
+
+func Test_TwoTierCache_Expiry(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	disk, err := NewDiskCache(dir, 1, time.Hour, stringSerializer{})
+	a.NoError(err)
+	tc := NewTwoTierCache(NewCache("my-cache", 1, 100, time.Hour), disk)
+
+	tc.Set("a", "http://a", 1, "a", time.Now().Add(-time.Minute))
+	tc.Set("b", "http://b", 1, "b", time.Time{})
+	waitForDemotions(t, tc)
+
+	// the expired entry on disk is only returned stale
+	_, found := tc.Get("a")
+	a.False(found)
+	v, staleFor, found := tc.GetStale("a")
+	a.True(found)
+	a.Equal("a", v)
+	a.True(staleFor >= time.Minute)
+}
+
+func Test_TwoTierCache_SlowDisk(t *testing.T) {
+	a := assert.New(t)
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	serializer := slowSerializer{release: make(chan struct{})}
+	disk, err := NewDiskCache(dir, 1, time.Hour, serializer)
+	a.NoError(err)
+	tc := newTwoTierCache(NewCache("my-cache", 1, 100, time.Hour), disk, 2)
+
+	// Set does not block, while the disk is blocked
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			tc.Set(fmt.Sprintf("key%v", i), "", 1, fmt.Sprintf("value%v", i), time.Time{}, "tag")
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Set blocked on the disk")
+	}
+
+	// the queued entries are found, while the others were dropped
+	v, found := tc.Get("key1")
+	a.True(found)
+	a.Equal("value1", v)
+	_, found = tc.Get("key8")
+	a.False(found)
+	a.True(tc.Dropped() > 0)
+
+	// purged entries are not written afterwards
+	a.True(len(tc.PurgeByTag("tag")) >= 2)
+	a.Equal(0, tc.Memory().Len())
+
+	close(serializer.release)
+	tc.Close()
+	a.Equal(0, tc.Disk().Len())
+}
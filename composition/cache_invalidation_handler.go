@@ -1,11 +1,24 @@
 package composition
 
 import (
+	"encoding/json"
 	"github.com/tarent/go-log-middleware/v2/logging"
+	"github.com/tarent/lib-compose/v2/cache"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
+// CacheInvalidationSummary is the response of a selective purge by the CacheInvalidationHandler.
+type CacheInvalidationSummary struct {
+	Purged  int                 `json:"purged"`
+	Entries []cache.PurgedEntry `json:"entries"`
+}
+
+// CacheInvalidationHandler invalidates the cache on DELETE requests to .../internal/cache.
+// Without query parameters, the whole cache is invalidated and the request is passed to the next handler.
+// Selected entries are purged by the query parameters url, prefix, tag and name, which may be repeated.
+// This needs a SelectiveCache and responds with a JSON CacheInvalidationSummary.
 type CacheInvalidationHandler struct {
 	cache Cache
 	next  http.Handler
@@ -15,6 +28,10 @@ func (cih *CacheInvalidationHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 	if r.Method == "DELETE" &&
 		strings.Contains(r.URL.EscapedPath(), "internal/cache") &&
 		cih.cache != nil {
+		if query := r.URL.Query(); isSelectivePurge(query) {
+			cih.purgeSelected(w, r, query)
+			return
+		}
 		logging.Application(r.Header).Info("cache was invalidated")
 		cih.cache.Invalidate()
 	}
@@ -23,6 +40,46 @@ func (cih *CacheInvalidationHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 	}
 }
 
+func isSelectivePurge(query url.Values) bool {
+	return len(query["url"]) > 0 || len(query["prefix"]) > 0 || len(query["tag"]) > 0 || len(query["name"]) > 0
+}
+
+// purgeSelected purges the entries selected by the query and writes the summary.
+func (cih *CacheInvalidationHandler) purgeSelected(w http.ResponseWriter, r *http.Request, query url.Values) {
+	selectiveCache, ok := cih.cache.(SelectiveCache)
+	if !ok {
+		http.Error(w, "selective purging is not supported by the cache", http.StatusNotImplemented)
+		return
+	}
+
+	summary := CacheInvalidationSummary{Entries: []cache.PurgedEntry{}}
+	purged := func(entries []cache.PurgedEntry) {
+		summary.Entries = append(summary.Entries, entries...)
+	}
+	for _, u := range query["url"] {
+		purged(selectiveCache.PurgeByLabel(u))
+	}
+	for _, prefix := range query["prefix"] {
+		purged(selectiveCache.PurgeByLabelPrefix(prefix))
+	}
+	for _, tag := range query["tag"] {
+		purged(selectiveCache.PurgeByTag(tag))
+	}
+	for _, name := range query["name"] {
+		purged(selectiveCache.PurgeByTag(nameTag(name)))
+	}
+	summary.Purged = len(summary.Entries)
+
+	logging.Application(r.Header).
+		WithField("query", r.URL.RawQuery).
+		Infof("purged %v cache entries", summary.Purged)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		logging.Application(r.Header).WithError(err).Error("error writing cache invalidation summary")
+	}
+}
+
 func NewCacheInvalidationHandler(cache Cache, next http.Handler) *CacheInvalidationHandler {
 	return &CacheInvalidationHandler{cache: cache, next: next}
 }
@@ -1,10 +1,15 @@
 package composition
 
 import (
+	"encoding/json"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/v2/cache"
 	mockhttp "github.com/tarent/lib-compose/v2/composition/mocks/net/http"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_CacheInvalidationHandler_Invalidation(t *testing.T) {
@@ -36,3 +41,53 @@ func Test_CacheInvalidationHandler_Delegate_Is_Called(t *testing.T) {
 	handlerMock.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(1)
 	cih.ServeHTTP(nil, request)
 }
+
+func Test_CacheInvalidationHandler_SelectivePurge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given
+	handlerMock := mockhttp.NewMockHandler(ctrl)
+	contentCache := cache.NewCache("test", 100, 100, time.Hour)
+	contentCache.Set("nav", "http://example.de/nav", 1, nil, time.Time{}, nameTag("navigation"))
+	contentCache.Set("teaser", "http://example.de/teaser/1", 1, nil, time.Time{}, "prices")
+	contentCache.Set("footer", "http://example.de/footer", 1, nil, time.Time{})
+	contentCache.Set("other", "http://example.de/other", 1, nil, time.Time{})
+	cih := NewCacheInvalidationHandler(contentCache, handlerMock)
+
+	//when
+	request, _ := http.NewRequest(http.MethodDelete, "/internal/cache?name=navigation&tag=prices&url=http://example.de/footer&prefix=http://example.org", nil)
+	recorder := httptest.NewRecorder()
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	a.Equal(200, recorder.Code)
+	a.Equal("application/json", recorder.Header().Get("Content-Type"))
+	summary := CacheInvalidationSummary{}
+	a.NoError(json.Unmarshal(recorder.Body.Bytes(), &summary))
+	a.Equal(3, summary.Purged)
+	a.Equal([]cache.PurgedEntry{
+		{Key: "footer", Label: "http://example.de/footer"},
+		{Key: "teaser", Label: "http://example.de/teaser/1"},
+		{Key: "nav", Label: "http://example.de/nav"},
+	}, summary.Entries)
+	a.Equal(1, contentCache.Len())
+}
+
+func Test_CacheInvalidationHandler_SelectivePurge_NotSupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	//given
+	cih := NewCacheInvalidationHandler(NewMockCache(ctrl), nil)
+
+	//when
+	request, _ := http.NewRequest(http.MethodDelete, "/internal/cache?tag=prices", nil)
+	recorder := httptest.NewRecorder()
+	cih.ServeHTTP(recorder, request)
+
+	//then
+	a.Equal(http.StatusNotImplemented, recorder.Code)
+}
@@ -2,63 +2,509 @@ package composition
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"github.com/pquerna/cachecontrol/cacheobject"
 	"github.com/tarent/go-log-middleware/v2/logging"
+	"github.com/tarent/lib-compose/v2/cache"
+	"golang.org/x/net/html"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type CachingContentLoader struct {
-	httpContentLoader ContentLoader
-	fileContentLoader ContentLoader
-	cache             Cache
+	httpContentLoader    ContentLoader
+	fileContentLoader    ContentLoader
+	cache                Cache
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	coalescing           bool
+	revalidations        struct {
+		running map[string]bool
+		mutex   sync.Mutex
+	}
+	inflight struct {
+		loads map[string]*inflightLoad
+		mutex sync.Mutex
+	}
+}
+
+// inflightLoad is a load, which is shared by all callers requesting the same hash.
+type inflightLoad struct {
+	done    chan struct{} // closed, when the load has finished
+	fd      *FetchDefinition
+	c       Content
+	err     error
+	waiters int
+	cancel  context.CancelFunc
 }
 
 func NewCachingContentLoader(cache Cache) *CachingContentLoader {
-	return &CachingContentLoader{
+	loader := &CachingContentLoader{
 		httpContentLoader: NewHttpContentLoader(),
 		fileContentLoader: NewFileContentLoader(),
 		cache:             cache,
 	}
+	loader.revalidations.running = make(map[string]bool)
+	loader.inflight.loads = make(map[string]*inflightLoad)
+	return loader
+}
+
+// WithLoader sets the loader for the http contents, which are not found in the cache. The default is a HttpContentLoader.
+// To protect the backends by a circuit breaker, while cache hits are still served, the CircuitBreakerContentLoader
+// is put between the cache and the http loader:
+//
+//	NewCachingContentLoader(cache).WithLoader(NewCircuitBreakerContentLoader(NewHttpContentLoader()))
+//
+// So only real requests to the backends are recorded by the circuit breaker.
+func (loader *CachingContentLoader) WithLoader(httpContentLoader ContentLoader) *CachingContentLoader {
+	loader.httpContentLoader = httpContentLoader
+	return loader
+}
+
+// WithRequestCoalescing enables sharing of one load between all concurrent callers,
+// which request a GET with the same hash, while it is not in the cache.
+// Streams of shared loads are buffered, to be readable by all callers.
+// Each caller waits until its own timeout, or until its context is done. The load is cancelled, if no caller is waiting anymore.
+func (loader *CachingContentLoader) WithRequestCoalescing(coalescing bool) *CachingContentLoader {
+	loader.coalescing = coalescing
+	return loader
+}
+
+// WithStaleWhileRevalidate enables serving expired contents out of the cache, while they are refreshed in the background.
+// Contents are served stale within the supplied window after their expiry,
+// or within the window of the stale-while-revalidate directive of the Cache-Control header of the response, if present.
+// This needs a StaleCache, which keeps the entries after their expiry (see cache.Cache.SetStaleRetention()).
+func (loader *CachingContentLoader) WithStaleWhileRevalidate(window time.Duration) *CachingContentLoader {
+	loader.staleWhileRevalidate = window
+	return loader
+}
+
+// WithStaleIfError enables serving expired contents out of the cache, if loading them fails
+// with a server error, a timeout or a connection error. The supplied maximum staleness is the time after the expiry,
+// in which the content may be served, or the stale-if-error directive of the Cache-Control header of the response, if present.
+// Such contents implement StaleContent. This needs a StaleCache, which keeps the entries after their expiry.
+func (loader *CachingContentLoader) WithStaleIfError(maxStaleness time.Duration) *CachingContentLoader {
+	loader.staleIfError = maxStaleness
+	return loader
 }
 
 func (loader *CachingContentLoader) Load(fd *FetchDefinition) (Content, error) {
+	return loader.LoadWithContext(context.Background(), fd)
+}
+
+// LoadWithContext returns the content from the cache, or loads it with the context.
+func (loader *CachingContentLoader) LoadWithContext(ctx context.Context, fd *FetchDefinition) (Content, error) {
 	hash := fd.Hash()
 
+	var stale Content
+	var staleFor time.Duration
 	if fd.Method == "GET" && fd.IsReadableFromCache() {
-		if cFromCache, exist := loader.cache.Get(hash); exist {
-			logging.Cacheinfo(fd.URL, true)
-			return cFromCache.(Content), nil
+		if cFromCache, cStaleFor, exist := loader.getFromCache(fd, hash); exist {
+			if cStaleFor < 0 {
+				logging.Cacheinfo(fd.URL, true)
+				return cFromCache, nil
+			}
+			if cStaleFor < loader.staleWhileRevalidateWindow(cFromCache) {
+				logging.Cacheinfo(fd.URL, true)
+				loader.revalidate(fd, hash, cFromCache)
+				return cFromCache, nil
+			}
+			stale, staleFor = cFromCache, cStaleFor
 		}
 	}
 	logging.Cacheinfo(fd.URL, false)
-	c, err := loader.load(fd)
+	var c Content
+	var err error
+	if loader.coalescing && fd.Method == "GET" {
+		c, err = loader.loadCoalesced(ctx, fd, hash, stale)
+	} else {
+		c, err = loader.loadAndStore(ctx, fd, hash, stale)
+	}
 	if err == nil {
-		if fd.IsCacheable(c.HttpStatusCode(), c.HttpHeader()) {
-			if c.Reader() != nil {
-				var streamBytes []byte
-				streamBytes, err = ioutil.ReadAll(c.Reader())
-				if err == nil {
-					cw := &ContentWrapper{
-						Content:     c,
-						streamBytes: streamBytes,
-					}
-					loader.cache.Set(hash, fd.URL, c.MemorySize(), cw)
-					return cw, nil
-				}
-			} else {
-				loader.cache.Set(hash, fd.URL, c.MemorySize(), c)
+		return c, nil
+	}
+
+	if stale != nil && ctx.Err() == nil && isServerError(c) && staleFor < loader.staleIfErrorWindow(stale) {
+		logging.Logger.WithError(err).
+			WithField("full_url", fd.URL).
+			Warnf("serving stale content for %v, stale for %v", fd.URL, staleFor)
+		return &staleContentWrapper{Content: stale, cause: err}, nil
+	}
+	return c, err
+}
+
+// loadCoalesced joins the running load for the hash, or starts a new one.
+func (loader *CachingContentLoader) loadCoalesced(ctx context.Context, fd *FetchDefinition, hash string, cached Content) (Content, error) {
+	loader.inflight.mutex.Lock()
+	load, running := loader.inflight.loads[hash]
+	if !running {
+		load = loader.startInflightLoad(fd, hash, cached)
+	}
+	load.waiters++
+	loader.inflight.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if fd.Timeout > 0 {
+		timer := time.NewTimer(fd.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-load.done:
+		if load.err == nil && variantHash(fd, hash, load.c) != variantHash(load.fd, hash, load.c) {
+			// the response varies on request headers, which differ from those of the shared load
+			return loader.loadAndStore(ctx, fd, hash, cached)
+		}
+		return load.c, load.err
+	case <-ctx.Done():
+		loader.leaveInflightLoad(load, hash)
+		return newFailedContent(fd), ctx.Err()
+	case <-timeout:
+		loader.leaveInflightLoad(load, hash)
+		c := newFailedContent(fd)
+		c.httpStatusCode = http.StatusGatewayTimeout
+		return c, fmt.Errorf("timeout after %v on loading url %q", fd.Timeout, fd.URL)
+	}
+}
+
+// startInflightLoad starts the shared load for the hash.
+// The method has to be called in a locked mutex block.
+func (loader *CachingContentLoader) startInflightLoad(fd *FetchDefinition, hash string, cached Content) *inflightLoad {
+	// the load is independent of the context of the first caller
+	ctx, cancel := context.WithCancel(context.Background())
+	definitionCopy := *fd
+	load := &inflightLoad{
+		done:   make(chan struct{}),
+		fd:     &definitionCopy,
+		cancel: cancel,
+	}
+	loader.inflight.loads[hash] = load
+
+	go func() {
+		defer cancel()
+		c, err := loader.loadAndStore(ctx, &definitionCopy, hash, cached)
+		if err == nil && c.Reader() != nil {
+			if _, isWrapper := c.(*ContentWrapper); !isWrapper {
+				// the stream of an uncachable content has to be buffered, to be readable by all callers
+				c, err = bufferStream(c)
 			}
 		}
+
+		loader.inflight.mutex.Lock()
+		if loader.inflight.loads[hash] == load {
+			delete(loader.inflight.loads, hash)
+		}
+		loader.inflight.mutex.Unlock()
+
+		load.c, load.err = c, err
+		close(load.done)
+	}()
+	return load
+}
+
+// leaveInflightLoad removes a waiting caller from the load and cancels it, if nobody is waiting anymore.
+func (loader *CachingContentLoader) leaveInflightLoad(load *inflightLoad, hash string) {
+	loader.inflight.mutex.Lock()
+	defer loader.inflight.mutex.Unlock()
+	load.waiters--
+	if load.waiters == 0 {
+		load.cancel()
+		if loader.inflight.loads[hash] == load {
+			delete(loader.inflight.loads, hash)
+		}
 	}
-	return c, err
 }
 
-func (loader *CachingContentLoader) load(fd *FetchDefinition) (Content, error) {
+// bufferStream reads the stream of the content into a ContentWrapper.
+func bufferStream(c Content) (Content, error) {
+	defer c.Reader().Close()
+	streamBytes, err := ioutil.ReadAll(c.Reader())
+	if err != nil {
+		return c, err
+	}
+	return &ContentWrapper{Content: c, streamBytes: streamBytes}, nil
+}
+
+// isServerError returns true, if the content of a failed load is missing or has a server error status.
+func isServerError(c Content) bool {
+	return c == nil || c.HttpStatusCode() >= 500
+}
+
+// getFromCache returns the content for the request out of the cache.
+// If the cached response varies on request headers, the variant for the request is looked up in a second step.
+// staleFor is the time since the expiry of the entry, which is only >= 0 for a StaleCache.
+func (loader *CachingContentLoader) getFromCache(fd *FetchDefinition, hash string) (c Content, staleFor time.Duration, found bool) {
+	cacheObject, staleFor, found := loader.lookup(hash)
+	if marker, isMarker := cacheObject.(*varyMarker); found && isMarker {
+		cacheObject, staleFor, found = loader.lookup(cache.HashWithVary(hash, marker.varyHeaders, fd.Header))
+	}
+	if !found {
+		return nil, 0, false
+	}
+	return cacheObject.(Content), staleFor, true
+}
+
+// lookup returns the entry out of the cache, including expired ones of a StaleCache.
+func (loader *CachingContentLoader) lookup(hash string) (cacheObject interface{}, staleFor time.Duration, found bool) {
+	if staleCache, ok := loader.cache.(StaleCache); ok {
+		return staleCache.GetStale(hash)
+	}
+	cacheObject, found = loader.cache.Get(hash)
+	return cacheObject, -1, found
+}
+
+// staleWhileRevalidateWindow returns the time, the content may be served stale while it is revalidated.
+func (loader *CachingContentLoader) staleWhileRevalidateWindow(c Content) time.Duration {
+	if loader.staleWhileRevalidate <= 0 {
+		return 0
+	}
+	if directives := cacheControlDirectives(c); directives != nil && directives.StaleWhileRevalidate >= 0 {
+		return time.Duration(directives.StaleWhileRevalidate) * time.Second
+	}
+	return loader.staleWhileRevalidate
+}
+
+// staleIfErrorWindow returns the time, the content may be served stale if loading fails.
+func (loader *CachingContentLoader) staleIfErrorWindow(c Content) time.Duration {
+	if loader.staleIfError <= 0 {
+		return 0
+	}
+	if directives := cacheControlDirectives(c); directives != nil && directives.StaleIfError >= 0 {
+		return time.Duration(directives.StaleIfError) * time.Second
+	}
+	return loader.staleIfError
+}
+
+// cacheControlDirectives returns the parsed Cache-Control header of the content, or nil
+func cacheControlDirectives(c Content) *cacheobject.ResponseCacheDirectives {
+	if c.HttpHeader() == nil || c.HttpHeader().Get("Cache-Control") == "" {
+		return nil
+	}
+	directives, err := cacheobject.ParseResponseCacheControl(c.HttpHeader().Get("Cache-Control"))
+	if err != nil {
+		return nil
+	}
+	return directives
+}
+
+// revalidate loads the content in the background and stores it in the cache.
+// Only one revalidation per variant of a hash is running at a time.
+func (loader *CachingContentLoader) revalidate(fd *FetchDefinition, hash string, cached Content) {
+	key := variantHash(fd, hash, cached)
+	loader.revalidations.mutex.Lock()
+	defer loader.revalidations.mutex.Unlock()
+	if loader.revalidations.running[key] {
+		return
+	}
+	loader.revalidations.running[key] = true
+
+	// the request of the fetch definition may be finished, before the revalidation
+	definitionCopy := *fd
+	go func() {
+		defer func() {
+			loader.revalidations.mutex.Lock()
+			defer loader.revalidations.mutex.Unlock()
+			delete(loader.revalidations.running, key)
+		}()
+
+		c, err := loader.loadAndStore(context.Background(), &definitionCopy, hash, cached)
+		if err != nil {
+			logging.Logger.WithError(err).Warnf("failed revalidating %v", definitionCopy.URL)
+			return
+		}
+		if c != cached && c.Reader() != nil {
+			// the content was not cacheable, so nobody reads the stream
+			c.Reader().Close()
+		}
+	}()
+}
+
+// loadAndStore loads the content and puts it into the cache.
+// An expired content out of the cache is revalidated by a conditional request, if it has an ETag or Last-Modified header.
+// If the backend responds with 304 Not Modified, the age of the cached content is refreshed and the cached content is returned.
+func (loader *CachingContentLoader) loadAndStore(ctx context.Context, fd *FetchDefinition, hash string, cached Content) (Content, error) {
+	conditionalFd, isConditional := conditionalDefinition(fd, cached)
+	c, err := loader.load(ctx, conditionalFd)
+	if err != nil {
+		return c, err
+	}
+	if isConditional && c.HttpStatusCode() == http.StatusNotModified {
+		logging.Logger.WithField("full_url", fd.URL).Debugf("cached content not modified %v", fd.URL)
+		expiry := expiryOf(c)
+		if expiry.IsZero() {
+			expiry = expiryOf(cached)
+		}
+		loader.put(fd, hash, cached, expiry)
+		return cached, nil
+	}
+	return loader.store(fd, hash, c)
+}
+
+// conditionalDefinition returns a copy of the fetch definition with If-None-Match and If-Modified-Since headers
+// out of the ETag and Last-Modified headers of the cached content.
+// Definitions with own conditional headers, e.g. forwarded from the client, are not changed.
+func conditionalDefinition(fd *FetchDefinition, cached Content) (*FetchDefinition, bool) {
+	if cached == nil || cached.HttpHeader() == nil {
+		return fd, false
+	}
+	if fd.Header.Get("If-None-Match") != "" || fd.Header.Get("If-Modified-Since") != "" {
+		return fd, false
+	}
+	etag := cached.HttpHeader().Get("ETag")
+	lastModified := cached.HttpHeader().Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return fd, false
+	}
+
+	conditionalFd := *fd
+	conditionalFd.Header = http.Header{}
+	for k, v := range fd.Header {
+		conditionalFd.Header[k] = v
+	}
+	if etag != "" {
+		conditionalFd.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		conditionalFd.Header.Set("If-Modified-Since", lastModified)
+	}
+	return &conditionalFd, true
+}
+
+// store puts the content into the cache, if it is cacheable.
+// The expiry is taken from the response headers, if present (see expiryOf()).
+// Streams are read to be stored and replaced by a ContentWrapper.
+func (loader *CachingContentLoader) store(fd *FetchDefinition, hash string, c Content) (Content, error) {
+	if !fd.IsCacheable(c.HttpStatusCode(), c.HttpHeader()) {
+		return c, nil
+	}
+	if c.Reader() != nil {
+		streamBytes, err := ioutil.ReadAll(c.Reader())
+		if err != nil {
+			return c, err
+		}
+		cw := &ContentWrapper{
+			Content:     c,
+			streamBytes: streamBytes,
+		}
+		loader.put(fd, hash, cw, expiryOf(c))
+		return cw, nil
+	}
+	loader.put(fd, hash, c, expiryOf(c))
+	return c, nil
+}
+
+// varyMarker is stored under the hash of a resource, which varies on request headers.
+// The variants are stored under the hashes, which include the values of those headers.
+type varyMarker struct {
+	varyHeaders []string
+}
+
+// put sets the content into the cache, tagged by its cacheTags(). If the content varies on request headers,
+// a varyMarker is set for the hash and the content is set for the variant of the request.
+func (loader *CachingContentLoader) put(fd *FetchDefinition, hash string, c Content, expiry time.Time) {
+	tags := cacheTags(fd, c)
+	if varyHeaders := cache.VaryHeaders(c.HttpHeader()); len(varyHeaders) > 0 {
+		loader.cache.Set(hash, fd.URL, 0, &varyMarker{varyHeaders: varyHeaders}, time.Time{}, tags...)
+		hash = cache.HashWithVary(hash, varyHeaders, fd.Header)
+	}
+	loader.cache.Set(hash, fd.URL, c.MemorySize(), c, expiry, tags...)
+}
+
+// cacheTags returns the tags of a cache entry: The surrogate keys out of the SurrogateKeyHeaders of the response
+// and the nameTag() of the fetch definition.
+func cacheTags(fd *FetchDefinition, c Content) []string {
+	tags := []string{nameTag(fd.Name)}
+	if c.HttpHeader() == nil {
+		return tags
+	}
+	for _, headerName := range SurrogateKeyHeaders {
+		for _, value := range c.HttpHeader()[headerName] {
+			tags = append(tags, strings.FieldsFunc(value, func(r rune) bool {
+				return r == ' ' || r == ','
+			})...)
+		}
+	}
+	return tags
+}
+
+// nameTag returns the tag for the entries of the fetch definition with the name.
+func nameTag(name string) string {
+	return "name:" + name
+}
+
+// variantHash returns the hash of the variant of the content for the request.
+func variantHash(fd *FetchDefinition, hash string, c Content) string {
+	if c == nil {
+		return hash
+	}
+	if varyHeaders := cache.VaryHeaders(c.HttpHeader()); len(varyHeaders) > 0 {
+		return cache.HashWithVary(hash, varyHeaders, fd.Header)
+	}
+	return hash
+}
+
+// expiryOf returns the expiry of the content by the s-maxage or max-age directive of the Cache-Control header,
+// reduced by the Age header, or by the Expires header.
+// It returns the zero time, if the headers do not define an expiry, so that the default of the cache is used.
+func expiryOf(c Content) time.Time {
+	header := c.HttpHeader()
+	if header == nil {
+		return time.Time{}
+	}
+	now := time.Now()
+	if directives := cacheControlDirectives(c); directives != nil {
+		maxAge := directives.SMaxAge
+		if maxAge < 0 {
+			maxAge = directives.MaxAge
+		}
+		if maxAge >= 0 {
+			ttl := time.Duration(maxAge) * time.Second
+			if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+				ttl -= time.Duration(age) * time.Second
+			}
+			return now.Add(ttl)
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		// the Expires header is relative to the clock of the backend
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			return now.Add(expires.Sub(date))
+		}
+		return expires
+	}
+	return time.Time{}
+}
+
+func (loader *CachingContentLoader) load(ctx context.Context, fd *FetchDefinition) (Content, error) {
 	if strings.HasPrefix(fd.URL, FileURLPrefix) {
-		return loader.fileContentLoader.Load(fd)
+		return loadWithContext(ctx, loader.fileContentLoader, fd)
+	}
+	return loadWithContext(ctx, loader.httpContentLoader, fd)
+}
+
+// staleContentWrapper marks a content, which is served stale out of the cache.
+type staleContentWrapper struct {
+	Content
+	cause error
+}
+
+func (sc *staleContentWrapper) StaleCause() error {
+	return sc.cause
+}
+
+// BodyAttributesArray forwards the body attributes of the wrapped content, so the wrapper is a ContentV2.
+func (sc *staleContentWrapper) BodyAttributesArray() []html.Attribute {
+	if contentV2, ok := sc.Content.(ContentV2); ok {
+		return contentV2.BodyAttributesArray()
 	}
-	return loader.httpContentLoader.Load(fd)
+	return nil
 }
 
 type ContentWrapper struct {
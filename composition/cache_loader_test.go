@@ -1,13 +1,20 @@
 package composition
 
 import (
+	"context"
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/v2/cache"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_CacheLoader_Found(t *testing.T) {
@@ -119,7 +126,7 @@ func Test_CacheLoader_NotFound(t *testing.T) {
 		cacheMocK := NewMockCache(ctrl)
 		cacheMocK.EXPECT().Get(gomock.Any()).Return(nil, false)
 		if test.cachable {
-			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), c)
+			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), c, gomock.Any(), nameTag(fd.Name))
 		}
 		// and a loader delegating to
 		loaderMock := NewMockContentLoader(ctrl)
@@ -167,7 +174,7 @@ func Test_CacheLoader_NotFound_With_Stream(t *testing.T) {
 		cacheMocK := NewMockCache(ctrl)
 		cacheMocK.EXPECT().Get(gomock.Any()).Return(nil, false)
 		if test.cachable {
-			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), CWMatcher{})
+			cacheMocK.EXPECT().Set(fd.Hash(), fd.URL, c.MemorySize(), CWMatcher{}, gomock.Any(), nameTag(fd.Name))
 		}
 		// and a loader delegating to
 		loaderMock := NewMockContentLoader(ctrl)
@@ -191,6 +198,367 @@ func Test_CacheLoader_NotFound_With_Stream(t *testing.T) {
 	}
 }
 
+func Test_CacheLoader_StaleWhileRevalidate(t *testing.T) {
+	a := assert.New(t)
+
+	var loads int32
+	revalidated := make(chan bool)
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		c.httpStatusCode = 200
+		c.meta["version"] = atomic.AddInt32(&loads, 1)
+		if c.meta["version"] == int32(2) {
+			<-revalidated
+		}
+		return c, nil
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, 10*time.Millisecond)
+	loader := NewCachingContentLoader(contentCache).WithStaleWhileRevalidate(time.Hour)
+	loader.httpContentLoader = backend
+	fd := NewFetchDefinition("http://example.de")
+
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	a.Equal(int32(1), c.Meta()["version"])
+
+	time.Sleep(15 * time.Millisecond)
+
+	// the stale content is returned and only one revalidation is started
+	for i := 0; i < 3; i++ {
+		c, err = loader.Load(fd)
+		a.NoError(err)
+		a.Equal(int32(1), c.Meta()["version"])
+	}
+	revalidated <- true
+
+	// the revalidated content is returned
+	for i := 0; i < 100 && c.Meta()["version"] == int32(1); i++ {
+		time.Sleep(time.Millisecond)
+		c, _ = loader.Load(fd)
+	}
+	a.Equal(int32(2), c.Meta()["version"])
+	a.Equal(int32(2), atomic.LoadInt32(&loads))
+}
+
+func Test_CacheLoader_StaleWhileRevalidate_Directive(t *testing.T) {
+	a := assert.New(t)
+
+	loader := NewCachingContentLoader(nil).WithStaleWhileRevalidate(time.Hour)
+
+	c := NewMemoryContent()
+	a.Equal(time.Hour, loader.staleWhileRevalidateWindow(c))
+
+	c.httpHeader = http.Header{"Cache-Control": {"max-age=10, stale-while-revalidate=30"}}
+	a.Equal(30*time.Second, loader.staleWhileRevalidateWindow(c))
+
+	a.Equal(time.Duration(0), NewCachingContentLoader(nil).staleWhileRevalidateWindow(c))
+}
+
+func Test_CacheLoader_StaleIfError(t *testing.T) {
+	a := assert.New(t)
+
+	status := 200
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		c.httpStatusCode = status
+		if status != 200 {
+			return c, errors.New("failed")
+		}
+		return c, nil
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, time.Millisecond)
+	loader := NewCachingContentLoader(contentCache).WithStaleIfError(time.Hour)
+	loader.httpContentLoader = backend
+	fd := NewFetchDefinition("http://example.de")
+
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	time.Sleep(2 * time.Millisecond)
+
+	// client errors are returned
+	status = 404
+	_, err = loader.Load(fd)
+	a.Error(err)
+
+	// on server errors, the stale content is returned
+	status = 503
+	stale, err := loader.Load(fd)
+	a.NoError(err)
+	a.Implements((*StaleContent)(nil), stale)
+	a.Equal("failed", stale.(StaleContent).StaleCause().Error())
+	a.Equal(c.HttpStatusCode(), stale.HttpStatusCode())
+
+	// but not after the maximum staleness
+	c.(*MemoryContent).httpHeader = http.Header{"Cache-Control": {"stale-if-error=0"}}
+	_, err = loader.Load(fd)
+	a.Error(err)
+}
+
+func Test_CacheLoader_WithCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+
+	calls := 0
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		calls++
+		c := NewMemoryContent()
+		if fd.URL == "http://backend/fail" {
+			c.httpStatusCode = 502
+			return c, errors.New("(http 502)")
+		}
+		c.httpStatusCode = 200
+		return c, nil
+	})
+	breaker := NewCircuitBreakerContentLoader(backend).WithFailureThreshold(1)
+	loader := NewCachingContentLoader(cache.NewCache("test", 100, 100, time.Hour)).WithLoader(breaker)
+
+	_, err := loader.Load(NewFetchDefinition("http://backend/ok"))
+	a.NoError(err)
+	_, err = loader.Load(NewFetchDefinition("http://backend/fail"))
+	a.Error(err)
+	a.Equal(CircuitOpen, breaker.State("backend"))
+
+	// the cached content is served, while the circuit is open
+	_, err = loader.Load(NewFetchDefinition("http://backend/ok"))
+	a.NoError(err)
+	a.Equal(2, calls)
+
+	// other contents are rejected by the circuit breaker
+	_, err = loader.Load(NewFetchDefinition("http://backend/other"))
+	a.IsType(&CircuitOpenError{}, err)
+	a.Equal(2, calls)
+}
+
+func Test_CacheLoader_RequestCoalescing(t *testing.T) {
+	a := assert.New(t)
+
+	var loads int32
+	release := make(chan bool)
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		c := NewMemoryContent()
+		c.httpStatusCode = 200
+		c.reader = ioutil.NopCloser(strings.NewReader("foobar"))
+		return c, nil
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, time.Hour)
+	loader := NewCachingContentLoader(contentCache).WithRequestCoalescing(true)
+	loader.httpContentLoader = backend
+	fd := NewFetchDefinition("http://example.de")
+
+	results := make(chan Content, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			c, err := loader.Load(fd)
+			a.NoError(err)
+			results <- c
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	release <- true
+
+	// all callers get the stream of the single load
+	for i := 0; i < 3; i++ {
+		c := <-results
+		b, err := ioutil.ReadAll(c.Reader())
+		a.NoError(err)
+		a.Equal("foobar", string(b))
+	}
+	a.Equal(int32(1), atomic.LoadInt32(&loads))
+}
+
+func Test_CacheLoader_RequestCoalescing_Timeout(t *testing.T) {
+	a := assert.New(t)
+
+	cancelled := make(chan bool, 1)
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		<-ctx.Done()
+		cancelled <- true
+		return NewMemoryContent(), ctx.Err()
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, time.Hour)
+	loader := NewCachingContentLoader(contentCache).WithRequestCoalescing(true)
+	loader.httpContentLoader = backend
+	fd := NewFetchDefinition("http://example.de")
+	fd.Timeout = 10 * time.Millisecond
+
+	c, err := loader.Load(fd)
+	a.Error(err)
+	a.Equal(http.StatusGatewayTimeout, c.HttpStatusCode())
+
+	// the shared load is cancelled, because nobody is waiting anymore
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		a.Fail("shared load was not cancelled")
+	}
+}
+
+func Test_CacheLoader_ConditionalRevalidation(t *testing.T) {
+	a := assert.New(t)
+
+	var loads, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&loads, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("foobar"))
+	}))
+	defer server.Close()
+
+	contentCache := cache.NewCache("test", 100, 100, 5*time.Millisecond)
+	loader := NewCachingContentLoader(contentCache)
+	fd := NewFetchDefinition(server.URL)
+
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	time.Sleep(10 * time.Millisecond)
+
+	// the expired content is revalidated and returned
+	revalidated, err := loader.Load(fd)
+	a.NoError(err)
+	a.Equal(int32(2), atomic.LoadInt32(&loads))
+	a.Equal(int32(1), atomic.LoadInt32(&notModified))
+	a.Equal(c, revalidated)
+	b, err := ioutil.ReadAll(revalidated.Reader())
+	a.NoError(err)
+	a.Equal("foobar", string(b))
+
+	// and its age is refreshed
+	_, found := contentCache.Get(fd.Hash())
+	a.True(found)
+}
+
+func Test_CacheLoader_ConditionalDefinition(t *testing.T) {
+	a := assert.New(t)
+
+	fd := NewFetchDefinition("http://example.de")
+	c := NewMemoryContent()
+
+	_, isConditional := conditionalDefinition(fd, nil)
+	a.False(isConditional)
+
+	_, isConditional = conditionalDefinition(fd, c)
+	a.False(isConditional)
+
+	c.httpHeader = http.Header{"Etag": {`"v1"`}}
+	conditionalFd, isConditional := conditionalDefinition(fd, c)
+	a.True(isConditional)
+	a.Equal(`"v1"`, conditionalFd.Header.Get("If-None-Match"))
+	a.Nil(fd.Header)
+
+	// own conditional headers are kept
+	fd.Header = http.Header{"If-None-Match": {`"v0"`}}
+	conditionalFd, isConditional = conditionalDefinition(fd, c)
+	a.False(isConditional)
+	a.Equal(`"v0"`, conditionalFd.Header.Get("If-None-Match"))
+}
+
+func Test_CacheLoader_ExpiryOf(t *testing.T) {
+	a := assert.New(t)
+
+	expiryIn := func(header http.Header) time.Duration {
+		c := NewMemoryContent()
+		c.httpHeader = header
+		expiry := expiryOf(c)
+		if expiry.IsZero() {
+			return 0
+		}
+		return time.Until(expiry).Round(time.Second)
+	}
+
+	a.Equal(time.Duration(0), expiryIn(nil))
+	a.Equal(time.Duration(0), expiryIn(http.Header{"Cache-Control": {"public"}}))
+	a.Equal(30*time.Second, expiryIn(http.Header{"Cache-Control": {"max-age=30"}}))
+	a.Equal(time.Hour, expiryIn(http.Header{"Cache-Control": {"max-age=30, s-maxage=3600"}}))
+	a.Equal(20*time.Second, expiryIn(http.Header{"Cache-Control": {"max-age=30"}, "Age": {"10"}}))
+	a.Equal(time.Minute, expiryIn(http.Header{
+		"Date":    {"Mon, 02 Jan 2006 15:04:05 GMT"},
+		"Expires": {"Mon, 02 Jan 2006 15:05:05 GMT"},
+	}))
+}
+
+func Test_CacheLoader_StoresWithExpiry(t *testing.T) {
+	a := assert.New(t)
+
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		c.httpStatusCode = 200
+		c.httpHeader = http.Header{"Cache-Control": {"max-age=0"}}
+		return c, nil
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, time.Hour)
+	loader := NewCachingContentLoader(contentCache)
+	loader.httpContentLoader = backend
+	fd := NewFetchDefinition("http://example.de")
+
+	_, err := loader.Load(fd)
+	a.NoError(err)
+
+	// the entry is expired by its own max-age
+	_, found := contentCache.Get(fd.Hash())
+	a.False(found)
+	_, _, found = contentCache.GetStale(fd.Hash())
+	a.True(found)
+}
+
+func Test_CacheLoader_Vary(t *testing.T) {
+	a := assert.New(t)
+
+	var loads int32
+	backend := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		atomic.AddInt32(&loads, 1)
+		c := NewMemoryContent()
+		c.httpStatusCode = 200
+		c.httpHeader = http.Header{"Vary": {"Accept-Language"}}
+		c.meta["lang"] = fd.Header.Get("Accept-Language")
+		return c, nil
+	})
+
+	contentCache := cache.NewCache("test", 100, 100, time.Hour)
+	loader := NewCachingContentLoader(contentCache)
+	loader.httpContentLoader = backend
+	fdFor := func(lang string) *FetchDefinition {
+		fd := NewFetchDefinition("http://example.de")
+		fd.Header = http.Header{"Accept-Language": {lang}}
+		return fd
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, lang := range []string{"de", "en"} {
+			c, err := loader.Load(fdFor(lang))
+			a.NoError(err)
+			a.Equal(lang, c.Meta()["lang"])
+		}
+	}
+	a.Equal(int32(2), atomic.LoadInt32(&loads))
+}
+
+func Test_CacheLoader_CacheTags(t *testing.T) {
+	a := assert.New(t)
+
+	fd := NewFetchDefinition("http://example.de").WithName("teaser")
+	c := NewMemoryContent()
+	a.Equal([]string{"name:teaser"}, cacheTags(fd, c))
+
+	c.httpHeader = http.Header{
+		"Surrogate-Key": {"prices product-42"},
+		"Cache-Tag":     {"layout,legal"},
+	}
+	a.Equal([]string{"name:teaser", "prices", "product-42", "layout", "legal"}, cacheTags(fd, c))
+}
+
 func Test_Content_Wrapper_Reader(t *testing.T) {
 	//given
 	toTest := &ContentWrapper{streamBytes: []byte("foobar")}
@@ -208,7 +576,7 @@ func Test_Content_Wrapper_Reader(t *testing.T) {
 type CWMatcher struct {
 }
 
-//Checks if a given object is a ContentWrapper
+// Checks if a given object is a ContentWrapper
 func (CWMatcher) Matches(cw interface{}) bool {
 	if reflect.TypeOf(cw) == reflect.TypeOf(&ContentWrapper{}) {
 		return true
@@ -0,0 +1,261 @@
+package composition
+
+import (
+	"context"
+	"fmt"
+	"github.com/tarent/go-log-middleware/v2/logging"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitFailureThreshold = 5
+	DefaultCircuitOpenDuration     = 10 * time.Second
+	DefaultCircuitHalfOpenRequests = 1
+	DefaultCircuitIdleTimeout      = 10 * time.Minute
+)
+
+// CircuitState is the state of the circuit for one backend.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests pass
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all requests immediately
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests pass, to check if the backend has recovered
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitOpenError is returned by the CircuitBreakerContentLoader, if the circuit for the backend is open.
+// The content of such results has the status code 503.
+type CircuitOpenError struct {
+	Backend string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for backend %v", e.Backend)
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int       // consecutive failures in closed state
+	openedAt time.Time // the time of the last opening
+	probes   int       // running requests in half-open state
+	running  int       // running requests in any state
+	lastUsed time.Time // the time of the last request
+}
+
+// CircuitBreakerContentLoader is a ContentLoader, which wraps another loader with a circuit breaker per backend.
+// The backend is the host of the url, which is the service name, if ServiceDiscoveryActive is set.
+// After FailureThreshold consecutive failures, the circuit opens and all fetches to the backend fail
+// immediately with a CircuitOpenError. After the OpenDuration, a limited number of probe requests is let through (half-open).
+// If they succeed, the circuit closes again, otherwise it opens again.
+// Failures are errors with a status code >= 500 or without content. Cancelled fetches are not counted.
+// Closed circuits without requests for the IdleTimeout are removed, so that backends of dynamic urls
+// do not accumulate.
+type CircuitBreakerContentLoader struct {
+	loader           ContentLoader
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenRequests int
+	idleTimeout      time.Duration
+	circuits         map[string]*circuit
+	lastPrune        time.Time
+	mutex            sync.Mutex
+}
+
+// NewCircuitBreakerContentLoader wraps the loader with circuit breakers with the default settings.
+func NewCircuitBreakerContentLoader(loader ContentLoader) *CircuitBreakerContentLoader {
+	return &CircuitBreakerContentLoader{
+		loader:           loader,
+		failureThreshold: DefaultCircuitFailureThreshold,
+		openDuration:     DefaultCircuitOpenDuration,
+		halfOpenRequests: DefaultCircuitHalfOpenRequests,
+		idleTimeout:      DefaultCircuitIdleTimeout,
+		circuits:         make(map[string]*circuit),
+		lastPrune:        time.Now(),
+	}
+}
+
+// WithFailureThreshold sets the number of consecutive failures, which opens the circuit.
+func (loader *CircuitBreakerContentLoader) WithFailureThreshold(failures int) *CircuitBreakerContentLoader {
+	loader.failureThreshold = failures
+	return loader
+}
+
+// WithOpenDuration sets the time, the circuit stays open, before probe requests are let through.
+func (loader *CircuitBreakerContentLoader) WithOpenDuration(openDuration time.Duration) *CircuitBreakerContentLoader {
+	loader.openDuration = openDuration
+	return loader
+}
+
+// WithHalfOpenRequests sets the number of concurrent probe requests in half-open state.
+func (loader *CircuitBreakerContentLoader) WithHalfOpenRequests(requests int) *CircuitBreakerContentLoader {
+	loader.halfOpenRequests = requests
+	return loader
+}
+
+// WithIdleTimeout sets the time, after which closed circuits without requests are removed.
+func (loader *CircuitBreakerContentLoader) WithIdleTimeout(idleTimeout time.Duration) *CircuitBreakerContentLoader {
+	loader.idleTimeout = idleTimeout
+	return loader
+}
+
+func (loader *CircuitBreakerContentLoader) Load(fd *FetchDefinition) (Content, error) {
+	return loader.LoadWithContext(context.Background(), fd)
+}
+
+// LoadWithContext loads the content by the wrapped loader, if the circuit of the backend allows it.
+func (loader *CircuitBreakerContentLoader) LoadWithContext(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	backend := backendOf(fd)
+	if backend == "" {
+		return loadWithContext(ctx, loader.loader, fd)
+	}
+
+	if !loader.allow(backend) {
+		c := NewMemoryContent()
+		c.name = fd.Name
+		c.httpStatusCode = http.StatusServiceUnavailable
+		return c, &CircuitOpenError{Backend: backend}
+	}
+
+	c, err := loadWithContext(ctx, loader.loader, fd)
+	if err != nil && ctx.Err() != nil {
+		loader.release(backend)
+		return c, err
+	}
+	loader.record(backend, err == nil || (c != nil && c.HttpStatusCode() < 500))
+	return c, err
+}
+
+// State returns the current state of the circuit for the backend.
+func (loader *CircuitBreakerContentLoader) State(backend string) CircuitState {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+	if c, exist := loader.circuits[backend]; exist {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// allow returns true, if a request to the backend may be sent.
+func (loader *CircuitBreakerContentLoader) allow(backend string) bool {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+
+	loader.prune()
+	c, exist := loader.circuits[backend]
+	if !exist {
+		c = &circuit{}
+		loader.circuits[backend] = c
+	}
+	c.lastUsed = time.Now()
+
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= loader.openDuration {
+		c.state = CircuitHalfOpen
+		c.probes = 0
+		logging.Logger.Infof("circuit half-open for backend %v", backend)
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if c.probes >= loader.halfOpenRequests {
+			return false
+		}
+		c.probes++
+	}
+	c.running++
+	return true
+}
+
+// prune removes the closed circuits, which had no requests within the idle timeout.
+// It runs at most once per idle timeout. The method has to be called in a locked mutex block.
+func (loader *CircuitBreakerContentLoader) prune() {
+	if time.Since(loader.lastPrune) < loader.idleTimeout {
+		return
+	}
+	loader.lastPrune = time.Now()
+	for backend, c := range loader.circuits {
+		if c.state == CircuitClosed && c.running == 0 && time.Since(c.lastUsed) >= loader.idleTimeout {
+			delete(loader.circuits, backend)
+		}
+	}
+}
+
+// record updates the circuit of the backend with the outcome of a request.
+func (loader *CircuitBreakerContentLoader) record(backend string, success bool) {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+
+	c := loader.circuits[backend]
+	c.running--
+	switch c.state {
+	case CircuitClosed:
+		if success {
+			c.failures = 0
+		} else if c.failures++; c.failures >= loader.failureThreshold {
+			loader.open(backend, c)
+		}
+	case CircuitHalfOpen:
+		c.releaseProbe()
+		if success {
+			c.state = CircuitClosed
+			c.failures = 0
+			logging.Logger.Infof("circuit closed for backend %v", backend)
+		} else {
+			loader.open(backend, c)
+		}
+	}
+}
+
+// release frees a probe of the circuit without an outcome, e.g. if the request was cancelled.
+func (loader *CircuitBreakerContentLoader) release(backend string) {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+
+	c := loader.circuits[backend]
+	c.running--
+	if c.state == CircuitHalfOpen {
+		c.releaseProbe()
+	}
+}
+
+// releaseProbe frees a probe. Probes of a former half-open phase are ignored.
+func (c *circuit) releaseProbe() {
+	if c.probes > 0 {
+		c.probes--
+	}
+}
+
+// open opens the circuit. The method has to be called in a locked mutex block.
+func (loader *CircuitBreakerContentLoader) open(backend string, c *circuit) {
+	c.state = CircuitOpen
+	c.openedAt = time.Now()
+	c.failures = 0
+	logging.Logger.Warnf("circuit open for backend %v", backend)
+}
+
+// backendOf returns the key of the circuit for a fetch definition.
+// This is the host of the url, or the service name, if the service discovery is active.
+func backendOf(fd *FetchDefinition) string {
+	parsedUrl, err := url.Parse(fd.URL)
+	if err != nil {
+		return ""
+	}
+	return parsedUrl.Host
+}
@@ -0,0 +1,120 @@
+package composition
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CircuitBreakerContentLoader(t *testing.T) {
+	a := assert.New(t)
+
+	calls := 0
+	failing := true
+	backendLoader := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		calls++
+		c := NewMemoryContent()
+		if failing {
+			c.httpStatusCode = 502
+			return c, errors.New("(http 502)")
+		}
+		c.httpStatusCode = 200
+		return c, nil
+	})
+
+	loader := NewCircuitBreakerContentLoader(backendLoader).
+		WithFailureThreshold(2).
+		WithOpenDuration(20 * time.Millisecond)
+	fd := NewFetchDefinition("http://backend/foo")
+
+	// closed: failures are passed through, until the threshold is reached
+	loader.Load(fd)
+	a.Equal(CircuitClosed, loader.State("backend"))
+	loader.Load(fd)
+	a.Equal(CircuitOpen, loader.State("backend"))
+	a.Equal(2, calls)
+
+	// open: fail fast
+	c, err := loader.Load(fd)
+	a.IsType(&CircuitOpenError{}, err)
+	a.Equal(503, c.HttpStatusCode())
+	a.Equal(2, calls)
+
+	// other backends are not affected
+	_, err = loader.Load(NewFetchDefinition("http://other/foo"))
+	a.NotNil(err)
+	a.Equal(3, calls)
+
+	// half-open: a failing probe opens the circuit again
+	time.Sleep(25 * time.Millisecond)
+	_, err = loader.Load(fd)
+	a.Equal("(http 502)", err.Error())
+	a.Equal(CircuitOpen, loader.State("backend"))
+
+	// half-open: a successful probe closes the circuit
+	time.Sleep(25 * time.Millisecond)
+	failing = false
+	_, err = loader.Load(fd)
+	a.NoError(err)
+	a.Equal(CircuitClosed, loader.State("backend"))
+}
+
+func Test_CircuitBreakerContentLoader_NotCountedFailures(t *testing.T) {
+	a := assert.New(t)
+
+	status := 404
+	backendLoader := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		c.httpStatusCode = status
+		return c, errors.New("failed")
+	})
+	loader := NewCircuitBreakerContentLoader(backendLoader).WithFailureThreshold(1)
+
+	// client errors are no failures of the backend
+	loader.Load(NewFetchDefinition("http://backend/foo"))
+	a.Equal(CircuitClosed, loader.State("backend"))
+
+	// cancelled requests are not counted
+	status = 502
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loader.LoadWithContext(ctx, NewFetchDefinition("http://backend/foo"))
+	a.Equal(CircuitClosed, loader.State("backend"))
+
+	loader.Load(NewFetchDefinition("http://backend/foo"))
+	a.Equal(CircuitOpen, loader.State("backend"))
+}
+
+func Test_CircuitBreakerContentLoader_PrunesIdleCircuits(t *testing.T) {
+	a := assert.New(t)
+
+	backendLoader := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		if fd.URL == "http://failing/" {
+			c.httpStatusCode = 502
+			return c, errors.New("(http 502)")
+		}
+		c.httpStatusCode = 200
+		return c, nil
+	})
+	loader := NewCircuitBreakerContentLoader(backendLoader).
+		WithFailureThreshold(1).
+		WithIdleTimeout(10 * time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		loader.Load(NewFetchDefinition(fmt.Sprintf("http://backend%v/", i)))
+	}
+	loader.Load(NewFetchDefinition("http://failing/"))
+	a.Equal(11, len(loader.circuits))
+	a.Equal(CircuitOpen, loader.State("failing"))
+
+	// closed idle circuits are removed, open ones are kept
+	time.Sleep(15 * time.Millisecond)
+	loader.Load(NewFetchDefinition("http://other/"))
+	a.Equal(2, len(loader.circuits))
+	a.Equal(CircuitOpen, loader.State("failing"))
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/tarent/go-log-middleware/v2/logging"
 )
@@ -13,10 +14,16 @@ import (
 // which can return the fetch results.
 type ContentFetcherFactory func(r *http.Request) FetchResultSupplier
 
+// StaleWarning is the Warning header, which is sent, if a content was served stale out of the cache.
+const StaleWarning = `110 - "Response is Stale"`
+
 type CompositionHandler struct {
 	contentFetcherFactory ContentFetcherFactory
 	contentMergerFactory  func(metaJSON map[string]interface{}) ContentMerger
 	cache                 Cache
+	streaming             bool
+	progressiveDeadline   time.Duration
+	escaping              Escaping
 }
 
 // NewCompositionHandler creates a new Handler with the supplied defaultData,
@@ -58,13 +65,63 @@ func (agg *CompositionHandler) WithDeduplicationStrategyFactory(strategyFactory
 	return agg
 }
 
+// Set the escaping of template variables to be used by the constructed content merger and content fetchers.
+// The default is EscapeHtml for the fragments and EscapeUrl for the urls of the fetch definitions.
+// With EscapeNone, the escaping is disabled for both.
+// The escaping is passed to the fetchers by the context of the request (see ContextWithEscaping()),
+// so it takes effect for fetchers created by NewContentFetcherWithContext(r.Context(), ...).
+// This method will first take effect in the upcomping call of ServeHTTP()
+func (agg *CompositionHandler) WithEscaping(escaping Escaping) *CompositionHandler {
+	agg.escaping = escaping
+	wrapped := agg.contentMergerFactory
+	agg.contentMergerFactory = func(metaJSON map[string]interface{}) ContentMerger {
+		cm := wrapped(metaJSON)
+		if escapingMerger, ok := cm.(EscapingContentMerger); ok {
+			escapingMerger.SetEscaping(escaping)
+		}
+		return cm
+	}
+	return agg
+}
+
+// WithStreaming enables the streaming of the html to the client, while it is rendered.
+// This needs a StreamingContentMerger, otherwise the html is rendered completely before it is sent.
+// In streaming mode, the head is flushed before the body is rendered and no Content-Length is sent.
+// If an error occurs after the first bytes were written, the response is incomplete
+// and no error status can be returned to the client.
+func (agg *CompositionHandler) WithStreaming(streaming bool) *CompositionHandler {
+	agg.streaming = streaming
+	return agg
+}
+
+// WithProgressiveRendering enables the streaming and renders the page, after all required contents are loaded
+// and the deadline has passed, without waiting for the optional contents.
+// Optional includes of contents, which are still pending, are rendered with their alternative content within a placeholder.
+// The fragments are streamed at the end of the body after their arrival and replace the placeholders.
+// This needs a ContentFetcher implementing ProgressiveFetchResultSupplier and a ProgressiveContentMerger.
+func (agg *CompositionHandler) WithProgressiveRendering(deadline time.Duration) *CompositionHandler {
+	agg.streaming = true
+	agg.progressiveDeadline = deadline
+	return agg
+}
+
 func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w}
+	defer func() {
+		compositionDuration.Observe(time.Since(start).Seconds(), recorder.statusLabel())
+	}()
+	w = recorder
+
 	// If we know the host but don't have the Host header [any more] then we
 	// set [or restore] the header, because why would You just remove it!?:
 	if (r.Host != "") && (r.Header.Get("Host") == "") {
 		r.Header.Set("Host", r.Host)
 	}
 
+	if agg.escaping != EscapeHtml {
+		r = r.WithContext(ContextWithEscaping(r.Context(), agg.escaping))
+	}
 	fetcher := agg.contentFetcherFactory(r)
 
 	if agg.handleEmptyFetcher(fetcher, w, r) {
@@ -72,7 +129,7 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 
 	// fetch all contents
-	results := fetcher.WaitForResults()
+	results, pending := agg.waitForResults(fetcher, r)
 
 	// Allow HEAD requests and disable composition of body fragments
 	if agg.handleHeadRequests(results, w, r) {
@@ -80,6 +137,12 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 
 	mergeContext := agg.contentMergerFactory(fetcher.MetaJSON())
+	progressiveMergeContext, isProgressive := mergeContext.(ProgressiveContentMerger)
+	if len(pending) > 0 && !isProgressive {
+		// the merger is not able to render pending contents, so we have to wait for them
+		results, pending = fetcher.WaitForResults(), nil
+		mergeContext = agg.contentMergerFactory(fetcher.MetaJSON())
+	}
 
 	for _, res := range results {
 		if res.Err == nil && res.Content != nil {
@@ -91,6 +154,14 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 			mergeContext.AddContent(res.Content, res.Def.Priority)
 
+			if res.Stale != nil {
+				logging.Application(r.Header).WithError(res.Stale).Warnf("stale content served: %v", res.Def.URL)
+				w.Header().Set("Warning", StaleWarning)
+			}
+		} else if _, cancelled := res.Err.(*FetchCancelledError); cancelled && res.Def.Required {
+			// the client is gone, so there is nobody to send an error page to
+			logging.Application(r.Header).WithError(res.Err).Infof("composition cancelled: %v", res.Def.URL)
+			return
 		} else if res.Def.Required {
 			LogFetchResultLoadingError(res, w, r)
 			return
@@ -106,6 +177,16 @@ func (agg *CompositionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	// Overwrite Content-Type to ensure, that the encoding is correct
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+	if len(pending) > 0 {
+		agg.streamHtmlProgressive(progressiveMergeContext, fetcher, status, results, pending, w, r)
+		return
+	}
+
+	if streamingMergeContext, isStreaming := mergeContext.(StreamingContentMerger); agg.streaming && isStreaming {
+		agg.streamHtml(streamingMergeContext, status, results, w, r)
+		return
+	}
+
 	html, err := agg.processHtml(mergeContext, w, r)
 	// Return if an error occured within the html aggregation
 	if err != nil {
@@ -175,6 +256,90 @@ func (agg *CompositionHandler) processHtml(mergeContext ContentMerger, w http.Re
 	return html, err
 }
 
+func (agg *CompositionHandler) streamHtml(mergeContext StreamingContentMerger, status int, results []*FetchResult, w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: status}
+	if err := mergeContext.WriteHtml(sw); err != nil {
+		logging.Application(r.Header).Error(err.Error())
+		agg.purgeCacheEntries(results)
+		if !sw.written {
+			http.Error(w, "Internal Server Error: "+err.Error(), 500)
+		}
+	}
+}
+
+// waitForResults waits for all results, or for the required results until the progressive deadline, if configured.
+func (agg *CompositionHandler) waitForResults(fetcher FetchResultSupplier, r *http.Request) (results, pending []*FetchResult) {
+	progressiveFetcher, isProgressive := fetcher.(ProgressiveFetchResultSupplier)
+	if agg.progressiveDeadline <= 0 || !isProgressive || r.Method == "HEAD" {
+		return fetcher.WaitForResults(), nil
+	}
+	return progressiveFetcher.WaitForResultsUntil(time.Now().Add(agg.progressiveDeadline))
+}
+
+func (agg *CompositionHandler) streamHtmlProgressive(mergeContext ProgressiveContentMerger, fetcher FetchResultSupplier, status int, results, pending []*FetchResult, w http.ResponseWriter, r *http.Request) {
+	pendingNames := make([]string, 0, len(pending))
+	for _, res := range pending {
+		pendingNames = append(pendingNames, res.Def.Name)
+	}
+	merged := make(map[*FetchResult]bool, len(results))
+	for _, res := range results {
+		merged[res] = true
+	}
+
+	// the pending contents include the dependencies of the pending contents, which were added later
+	waitForPending := func() []Content {
+		contents := []Content{}
+		for _, res := range fetcher.WaitForResults() {
+			if merged[res] {
+				continue
+			}
+			if res.Err != nil || res.Content == nil {
+				logging.Application(r.Header).WithField("fetchResult", res).Warnf("optional content not loaded: %v", res.Def.URL)
+				continue
+			}
+			if res.Content.Reader() != nil {
+				// streams can not be merged into a page, which is already sent
+				res.Content.Reader().Close()
+				logging.Application(r.Header).Warnf("optional content not mergeable, because it is a stream: %v", res.Def.URL)
+				continue
+			}
+			contents = append(contents, res.Content)
+		}
+		return contents
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: status}
+	if err := mergeContext.WriteHtmlProgressive(sw, pendingNames, waitForPending); err != nil {
+		logging.Application(r.Header).Error(err.Error())
+		agg.purgeCacheEntries(append(results, pending...))
+		if !sw.written {
+			http.Error(w, "Internal Server Error: "+err.Error(), 500)
+		}
+	}
+}
+
+// statusWriter delays the writing of the status code until the first write,
+// so that an error status can still be sent, if the rendering fails before.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if !sw.written {
+		sw.written = true
+		sw.ResponseWriter.WriteHeader(sw.status)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok && sw.written {
+		flusher.Flush()
+	}
+}
+
 func (agg *CompositionHandler) handleHeadRequests(results []*FetchResult, w http.ResponseWriter, r *http.Request) bool {
 	if r.Method == "HEAD" && len(results) > 0 {
 		copyHeaders(results[0].Content.HttpHeader(), w.Header(), ForwardResponseHeaders)
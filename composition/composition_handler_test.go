@@ -1,6 +1,7 @@
 package composition
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"io/ioutil"
@@ -65,6 +66,301 @@ func Test_CompositionHandler_PositiveCase(t *testing.T) {
 	a.Equal(200, resp.Code)
 }
 
+func Test_CompositionHandler_Streaming(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		frag := NewStringFragment("Hello World\n")
+		frag.AddStylesheets([][]html.Attribute{stylesheetAttrs("/path/to/style1.css")})
+
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def: NewFetchDefinition("/foo"),
+				Content: &MemoryContent{
+					body: map[string]Fragment{
+						"": frag,
+					},
+					httpStatusCode: 201,
+				},
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithStreaming(true)
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	expected := `<!DOCTYPE html>
+<html>
+  <head>
+    
+    <link rel="stylesheet" type="text/css" href="/path/to/style1.css">
+  </head>
+  <body>
+    Hello World
+
+  </body>
+</html>
+`
+	a.Equal(expected, string(resp.Body.Bytes()))
+	a.Equal(201, resp.Code)
+	a.True(resp.Flushed)
+	a.Equal("", resp.Header().Get("Content-Length"))
+}
+
+func Test_CompositionHandler_Streaming_ErrorInMerging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def:     NewFetchDefinition("/foo"),
+				Content: &MemoryContent{},
+			},
+		}
+	}
+	aggregator := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithStreaming(true)
+	aggregator.contentMergerFactory = func(jsonData map[string]interface{}) ContentMerger {
+		merger := NewMockContentMerger(ctrl)
+		merger.EXPECT().AddContent(gomock.Any(), 0)
+		merger.EXPECT().WriteHtml(gomock.Any()).Return(errors.New("an error"))
+		return merger
+	}
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	aggregator.ServeHTTP(resp, r)
+
+	a.Equal("Internal Server Error: an error\n", string(resp.Body.Bytes()))
+	a.Equal(500, resp.Code)
+}
+
+// plainContentMerger hides the optional interfaces of the wrapped ContentMerger
+type plainContentMerger struct {
+	ContentMerger
+}
+
+func Test_CompositionHandler_Streaming_MergerWithoutOptionalInterfaces(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def:     NewFetchDefinition("/foo"),
+				Content: &MemoryContent{},
+			},
+		}
+	}
+	aggregator := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+	aggregator.contentMergerFactory = func(jsonData map[string]interface{}) ContentMerger {
+		merger := NewMockContentMerger(ctrl)
+		merger.EXPECT().AddContent(gomock.Any(), 0)
+		merger.EXPECT().GetHtml().Return([]byte("<html/>"), nil)
+		return plainContentMerger{merger}
+	}
+	aggregator.WithStreaming(true).WithEscaping(EscapeNone)
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	aggregator.ServeHTTP(resp, r)
+
+	// the html is rendered completely, because the merger is not able to stream it
+	a.Equal("<html/>", string(resp.Body.Bytes()))
+	a.Equal("7", resp.Header().Get("Content-Length"))
+	a.Equal(200, resp.Code)
+}
+
+func Test_CompositionHandler_ProgressiveRendering(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockProgressiveFetchResultSupplier{
+			results: []*FetchResult{
+				&FetchResult{
+					Def: NewFetchDefinition("/foo"),
+					Content: &MemoryContent{
+						body: map[string]Fragment{
+							"": NewStringFragment("<main>§[#> slow]§loading§[/slow]§</main>"),
+						},
+					},
+				},
+			},
+			pending: []*FetchResult{
+				&FetchResult{
+					Def: &FetchDefinition{Name: "slow", URL: "/slow"},
+					Content: &MemoryContent{
+						name: "slow",
+						body: map[string]Fragment{
+							"": NewStringFragment("slow content"),
+						},
+					},
+				},
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithProgressiveRendering(time.Millisecond)
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	body := string(resp.Body.Bytes())
+	a.Equal(200, resp.Code)
+	a.True(resp.Flushed)
+	a.Contains(body, `<main><uic-pending id="uic-pending-1">loading</uic-pending></main>`)
+	a.Contains(body, `<template id="uic-pending-1-content">slow content</template>`)
+}
+
+func Test_CompositionHandler_ProgressiveRendering_DependenciesOfPendingContents(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockProgressiveFetchResultSupplier{
+			results: []*FetchResult{
+				&FetchResult{
+					Def: NewFetchDefinition("/foo"),
+					Content: &MemoryContent{
+						body: map[string]Fragment{
+							"": NewStringFragment("<main>§[#> slow]§loading§[/slow]§</main>"),
+						},
+					},
+				},
+			},
+			pending: []*FetchResult{
+				&FetchResult{
+					Def: &FetchDefinition{Name: "slow", URL: "/slow"},
+					Content: &MemoryContent{
+						name: "slow",
+						body: map[string]Fragment{
+							"": NewStringFragment("slow §[#> slower]§no slower content§[/slower]§"),
+						},
+					},
+				},
+			},
+			late: []*FetchResult{
+				&FetchResult{
+					Def: &FetchDefinition{Name: "slower", URL: "/slower"},
+					Content: &MemoryContent{
+						name: "slower",
+						body: map[string]Fragment{
+							"": NewStringFragment("slower content"),
+						},
+					},
+				},
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithProgressiveRendering(time.Millisecond)
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Contains(string(resp.Body.Bytes()), `<template id="uic-pending-1-content">slow slower content</template>`)
+}
+
+func Test_CompositionHandler_ProgressiveRendering_NotSupportedByMerger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockProgressiveFetchResultSupplier{
+			results: []*FetchResult{{Def: NewFetchDefinition("/foo"), Content: &MemoryContent{}}},
+			pending: []*FetchResult{{Def: NewFetchDefinition("/slow"), Content: &MemoryContent{}}},
+		}
+	}
+	aggregator := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithProgressiveRendering(time.Millisecond)
+	// the merger, which can not render pending contents, is replaced after waiting for all contents
+	mergerCount := 0
+	aggregator.contentMergerFactory = func(jsonData map[string]interface{}) ContentMerger {
+		mergerCount++
+		merger := NewMockContentMerger(ctrl)
+		if mergerCount == 2 {
+			merger.EXPECT().AddContent(gomock.Any(), 0).Times(2)
+			merger.EXPECT().WriteHtml(gomock.Any()).Return(nil)
+		}
+		return merger
+	}
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	aggregator.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Equal(2, mergerCount)
+}
+
+func Test_CompositionHandler_StaleContent(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		return MockFetchResultSupplier{
+			&FetchResult{
+				Def: NewFetchDefinition("/foo"),
+				Content: &MemoryContent{
+					body: map[string]Fragment{
+						"": NewStringFragment("Hello World"),
+					},
+				},
+				Stale: errors.New("backend down"),
+			},
+		}
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Equal(StaleWarning, resp.Header().Get("Warning"))
+}
+
+func Test_CompositionHandler_StaleContentFromCache(t *testing.T) {
+	a := assert.New(t)
+
+	status := 200
+	loader := NewCachingContentLoader(cache.NewCache("test", 100, 100, time.Millisecond)).WithStaleIfError(time.Hour)
+	loader.httpContentLoader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		c := NewMemoryContent()
+		c.httpStatusCode = status
+		if status != 200 {
+			return c, errors.New("backend down")
+		}
+		c.body[""] = NewStringFragment("Hello World")
+		c.bodyAttributes = []html.Attribute{{Key: "class", Val: "cached"}}
+		return c, nil
+	})
+	_, err := loader.Load(NewFetchDefinition("/foo"))
+	a.NoError(err)
+	time.Sleep(2 * time.Millisecond)
+	status = 503
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcher(nil)
+		fetcher.Loader = loader
+		fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+		return fetcher
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Equal(StaleWarning, resp.Header().Get("Warning"))
+	a.Contains(string(resp.Body.Bytes()), `<body class="cached">`)
+	a.Contains(string(resp.Body.Bytes()), "Hello World")
+}
+
 func Test_CompositionHandler_PositiveCaseWithSimpleDeduplicationStrategy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -137,7 +433,7 @@ func Test_CompositionHandler_PositiveCaseWithCache(t *testing.T) {
 
 	resp := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "http://example.com", nil)
-	ch.cache.Set("hashString", "", 1, nil)
+	ch.cache.Set("hashString", "", 1, nil, time.Time{})
 	ch.ServeHTTP(resp, r)
 
 	_, foundInCache := ch.cache.Get("hashString")
@@ -412,7 +708,7 @@ func Test_CompositionHandler_ErrorInMergingWithCache(t *testing.T) {
 	}
 
 	aggregator := NewCompositionHandlerWithCache(ContentFetcherFactory(contentFetcherFactory), cache.NewCache("my-cache", 100, 100, time.Millisecond))
-	aggregator.cache.Set("hashString", "", 1, nil)
+	aggregator.cache.Set("hashString", "", 1, nil, time.Time{})
 	aggregator.contentMergerFactory = func(jsonData map[string]interface{}) ContentMerger {
 		merger := NewMockContentMerger(ctrl)
 		merger.EXPECT().AddContent(gomock.Any(), 0)
@@ -457,6 +753,52 @@ func Test_CompositionHandler_ErrorInFetching(t *testing.T) {
 	a.Equal(502, resp.Code)
 }
 
+func Test_CompositionHandler_BudgetExceeded(t *testing.T) {
+	a := assert.New(t)
+
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcher(nil)
+		fetcher.SetBudget(0)
+		fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+		return fetcher
+	}
+	aggregator := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory))
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	aggregator.ServeHTTP(resp, r)
+
+	a.Equal("Error: budget exceeded on fetching /foo\n", string(resp.Body.Bytes()))
+	a.Equal(504, resp.Code)
+}
+
+func Test_CompositionHandler_WithEscaping(t *testing.T) {
+	a := assert.New(t)
+
+	// the fetch url and the fragment use the same unescaped variable
+	var requestedURL string
+	contentFetcherFactory := func(r *http.Request) FetchResultSupplier {
+		fetcher := NewContentFetcherWithContext(r.Context(), map[string]interface{}{"q": "<b>a b</b>"})
+		fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+			requestedURL = fd.URL
+			return &MemoryContent{
+				body: map[string]Fragment{"": NewStringFragment("<p>§[ q ]§</p>")},
+			}, nil
+		})
+		fetcher.AddFetchJob(NewFetchDefinition("/search?q=§[ q ]§"))
+		return fetcher
+	}
+	ch := NewCompositionHandler(ContentFetcherFactory(contentFetcherFactory)).WithEscaping(EscapeNone)
+
+	resp := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	ch.ServeHTTP(resp, r)
+
+	a.Equal(200, resp.Code)
+	a.Equal("/search?q=<b>a b</b>", requestedURL)
+	a.Contains(string(resp.Body.Bytes()), "<p><b>a b</b></p>")
+}
+
 func Test_CompositionHandler_ErrorEmptyFetchersList(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -588,3 +930,25 @@ func (m MockFetchResultSupplier) MetaJSON() map[string]interface{} {
 func (m MockFetchResultSupplier) Empty() bool {
 	return len([]*FetchResult(m)) == 0
 }
+
+type MockProgressiveFetchResultSupplier struct {
+	results []*FetchResult
+	pending []*FetchResult
+	late    []*FetchResult // the results of the dependencies of pending contents, which are added later
+}
+
+func (m MockProgressiveFetchResultSupplier) WaitForResults() []*FetchResult {
+	return append(append(append([]*FetchResult{}, m.results...), m.pending...), m.late...)
+}
+
+func (m MockProgressiveFetchResultSupplier) WaitForResultsUntil(deadline time.Time) ([]*FetchResult, []*FetchResult) {
+	return m.results, m.pending
+}
+
+func (m MockProgressiveFetchResultSupplier) MetaJSON() map[string]interface{} {
+	return nil
+}
+
+func (m MockProgressiveFetchResultSupplier) Empty() bool {
+	return len(m.results)+len(m.pending) == 0
+}
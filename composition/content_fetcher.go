@@ -1,10 +1,14 @@
 package composition
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/tarent/go-log-middleware/v2/logging"
+	"net/http"
 	"sort"
 	"sync"
+	"time"
 )
 
 type FetchResult struct {
@@ -12,6 +16,9 @@ type FetchResult struct {
 	Err     error
 	Content Content
 	Hash    string // the hash of the FetchDefinition
+	Stale   error  // the error of the loading, if the content is served stale out of the cache
+	done    bool   // true, if the fetch job has finished
+	loaded  bool   // true, if the fetch job has set its result, so that it is not marked as exceeded by the budget
 }
 
 //Provide implementation for sorting FetchResults by priority with sort.Sort
@@ -35,20 +42,53 @@ type FetchDefinitionFactory func(name string, params Params) (fd *FetchDefinitio
 
 // ContentFetcher is a type, which can fetch a set of Content pages in parallel.
 type ContentFetcher struct {
-	activeJobs sync.WaitGroup
-	r          struct {
+	r struct {
 		sheduledFetchDefinitionNames map[string]string
 		results                      []*FetchResult
 		mutex                        sync.Mutex
+		jobDone                      *sync.Cond // signaled with r.mutex, when a job has finished
 	}
 	meta struct {
 		json  map[string]interface{}
 		mutex sync.Mutex
 	}
+	budget struct { // guarded by r.mutex
+		ctx      context.Context // the context of the fetch jobs, which ends with the budget
+		cancel   context.CancelFunc
+		deadline time.Time // the end of the budget, or zero for no budget
+		timer    *time.Timer
+	}
 	lazyFdFactory FetchDefinitionFactory
+	escaping      Escaping
+	ctx           context.Context
 	Loader        ContentLoader
 }
 
+// FetchCancelledError is the error of a FetchResult, if the fetch job was cancelled
+// by the context of the ContentFetcher.
+type FetchCancelledError struct {
+	URL   string
+	Cause error // the error of the context
+}
+
+func (e *FetchCancelledError) Error() string {
+	return fmt.Sprintf("fetching %v cancelled: %v", e.URL, e.Cause)
+}
+
+func (e *FetchCancelledError) Unwrap() error {
+	return e.Cause
+}
+
+// BudgetExceededError is the error of a FetchResult, if the fetch job could not be finished
+// within the budget of the ContentFetcher. The content of such results has the status code 504.
+type BudgetExceededError struct {
+	URL string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget exceeded on fetching %v", e.URL)
+}
+
 // NewContentFetcher creates a ContentFetcher with an HtmlContentParser as default.
 // TODO: The FetchResults should always be returned in a predictable order,
 // independent of the actual response times of the fetch jobs.
@@ -56,7 +96,10 @@ func NewContentFetcher(defaultMetaJSON map[string]interface{}) *ContentFetcher {
 	f := &ContentFetcher{}
 	f.r.results = make([]*FetchResult, 0, 0)
 	f.r.sheduledFetchDefinitionNames = make(map[string]string)
+	f.r.jobDone = sync.NewCond(&f.r.mutex)
 	f.Loader = NewHttpContentLoader()
+	f.escaping = EscapeUrl
+	f.ctx = context.Background()
 	f.meta.json = defaultMetaJSON
 	if f.meta.json == nil {
 		f.meta.json = make(map[string]interface{})
@@ -67,6 +110,19 @@ func NewContentFetcher(defaultMetaJSON map[string]interface{}) *ContentFetcher {
 	return f
 }
 
+// NewContentFetcherWithContext creates a ContentFetcher, which is bound to the supplied context, e.g. r.Context().
+// If the context is done, the running fetch jobs are cancelled, if the Loader is a ContextContentLoader
+// and fetch jobs, which are not started yet, fail with a FetchCancelledError.
+// If the escaping is disabled by the context (see ContextWithEscaping()), the urls of the fetch definitions are not escaped.
+func NewContentFetcherWithContext(ctx context.Context, defaultMetaJSON map[string]interface{}) *ContentFetcher {
+	f := NewContentFetcher(defaultMetaJSON)
+	f.ctx = ctx
+	if escaping, found := EscapingFromContext(ctx); found && escaping == EscapeNone {
+		f.escaping = EscapeNone
+	}
+	return f
+}
+
 // SetFetchDefinitionFactory supplies a factory for lazy evaluated fetch jobs,
 // which will only be loaded if a fragment refrences them.
 // Seting the factory of optional, but if used, has to be done before adding Jobs by AddFetchJob.
@@ -74,15 +130,62 @@ func (fetcher *ContentFetcher) SetFetchDefinitionFactory(factory FetchDefinition
 	fetcher.lazyFdFactory = factory
 }
 
+// SetBudget limits the time for all fetch jobs of the fetcher, including the recursive and lazy dependencies.
+// The budget starts with this call, so it has to be done before adding jobs by AddFetchJob.
+// The fetch jobs are loaded with a context, which ends with the budget, and get the remaining budget as timeout,
+// if it is shorter than the timeout of their FetchDefinition.
+// Fetch jobs, which could not be finished within the budget, fail with a BudgetExceededError,
+// even if their loader does not respect the context, so that the waiting for the results ends with the budget.
+func (fetcher *ContentFetcher) SetBudget(budget time.Duration) {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+
+	if fetcher.budget.timer != nil {
+		fetcher.budget.timer.Stop()
+		fetcher.budget.cancel()
+	}
+	fetcher.budget.deadline = time.Now().Add(budget)
+	fetcher.budget.ctx, fetcher.budget.cancel = context.WithDeadline(fetcher.ctx, fetcher.budget.deadline)
+	fetcher.budget.timer = time.AfterFunc(budget, fetcher.expireBudget)
+}
+
+// expireBudget marks the jobs, which are not finished at the end of the budget, as exceeded
+// and notifies the waiting callers.
+func (fetcher *ContentFetcher) expireBudget() {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+
+	if time.Now().Before(fetcher.budget.deadline) {
+		return // the budget was set again in the meantime
+	}
+	fetcher.budget.cancel()
+	for _, res := range fetcher.r.results {
+		if !res.done && !res.loaded {
+			setBudgetExceeded(res)
+			res.done = true
+		}
+	}
+	fetcher.r.jobDone.Broadcast()
+}
+
+// SetEscaping sets the escaping of template variables in the urls of the fetch definitions.
+// The default is EscapeUrl.
+func (fetcher *ContentFetcher) SetEscaping(escaping Escaping) {
+	fetcher.escaping = escaping
+}
+
 // Wait blocks until all jobs are done,
 // either successful or with an error result and returns the content and errors.
+// If a budget is set, it returns at the latest at the end of the budget.
 // Do we need to return the Results in a special order????
 func (fetcher *ContentFetcher) WaitForResults() []*FetchResult {
-	fetcher.activeJobs.Wait()
-
 	fetcher.r.mutex.Lock()
 	defer fetcher.r.mutex.Unlock()
 
+	for !fetcher.allDone() {
+		fetcher.r.jobDone.Wait()
+	}
+
 	results := fetcher.r.results
 
 	// To keep initial order if no priority settings are given, do a check before for sorting.
@@ -93,6 +196,55 @@ func (fetcher *ContentFetcher) WaitForResults() []*FetchResult {
 	return results
 }
 
+// WaitForResultsUntil blocks until all jobs are done, or until the deadline is reached and all required jobs are done.
+// It returns the results of the finished jobs and the results of the optional jobs, which are still pending.
+// The pending results may be waited for by WaitForResults() afterwards.
+func (fetcher *ContentFetcher) WaitForResultsUntil(deadline time.Time) (results []*FetchResult, pending []*FetchResult) {
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		fetcher.r.mutex.Lock()
+		defer fetcher.r.mutex.Unlock()
+		fetcher.r.jobDone.Broadcast()
+	})
+	defer timer.Stop()
+
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+
+	for {
+		results, pending = nil, nil
+		requiredPending := false
+		for _, res := range fetcher.r.results {
+			if res.done {
+				results = append(results, res)
+			} else {
+				pending = append(pending, res)
+				requiredPending = requiredPending || res.Def.Required
+			}
+		}
+		if len(pending) == 0 || (!requiredPending && !time.Now().Before(deadline)) {
+			break
+		}
+		fetcher.r.jobDone.Wait()
+	}
+
+	// To keep initial order if no priority settings are given, do a check before for sorting.
+	if hasPrioritySetting(results) {
+		sort.Sort(FetchResults(results))
+	}
+	return results, pending
+}
+
+// allDone returns true, if all jobs are done.
+// The method has to be called in a locked mutex block.
+func (fetcher *ContentFetcher) allDone() bool {
+	for _, res := range fetcher.r.results {
+		if !res.done {
+			return false
+		}
+	}
+	return true
+}
+
 //func (fetcher *ContentFetcher) AddFetchDefinitionFactory(name string, func(params map[string]string) *FetchDefinition) {
 
 // AddFetchJob adds one job to the fetcher and recursively adds the dependencies also.
@@ -105,13 +257,25 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 		return
 	}
 
-	fetcher.activeJobs.Add(1)
 	fetchResult := &FetchResult{Def: d, Hash: hash, Err: errors.New("not fetched")}
 	fetcher.r.results = append(fetcher.r.results, fetchResult)
 	fetcher.r.sheduledFetchDefinitionNames[d.Name] = d.Name
 
+	ctx, deadline := fetcher.ctx, fetcher.budget.deadline
+	if fetcher.budget.ctx != nil {
+		ctx = fetcher.budget.ctx
+	}
+
 	go func() {
-		defer fetcher.activeJobs.Done()
+		defer fetcher.markDone(fetchResult)
+
+		// the result is collected in a copy, because the job may be marked as exceeded by the budget meanwhile
+		result := &FetchResult{Def: d, Hash: hash}
+		if ctx.Err() != nil {
+			fetcher.interrupt(result)
+			fetcher.finish(fetchResult, result)
+			return
+		}
 
 		url, err := fetcher.expandTemplateVars(d.URL)
 		if err != nil {
@@ -126,15 +290,43 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 		// want to override the original URL with expanded values.
 		definitionCopy := *d
 		definitionCopy.URL = url
-		fetchResult.Content, fetchResult.Err = fetcher.Loader.Load(&definitionCopy)
 
-		if fetchResult.Err == nil {
-			fetcher.addMeta(fetchResult.Content.Meta())
-			fetcher.addDependentFetchJobs(fetchResult.Content)
+		if !deadline.IsZero() {
+			if remaining := time.Until(deadline); remaining < definitionCopy.Timeout {
+				definitionCopy.Timeout = remaining
+			}
+		}
+
+		start := time.Now()
+		result.Content, result.Err = loadWithContext(ctx, fetcher.Loader, &definitionCopy)
+		// the deadline is checked as well, because the context may not be done yet, when the timeout ends with the budget
+		if result.Err != nil && (ctx.Err() != nil || (!deadline.IsZero() && !time.Now().Before(deadline))) {
+			fetcher.interrupt(result)
+		}
+
+		if staleContent, isStale := result.Content.(StaleContent); isStale && result.Err == nil {
+			result.Stale = staleContent.StaleCause()
+		}
+		if !fetcher.finish(fetchResult, result) {
+			setBudgetExceeded(result)
+		}
+		observeFetch(d, result, time.Since(start))
+
+		if result.Err == nil {
+			fetcher.addMeta(result.Content.Meta())
+			fetcher.addDependentFetchJobs(result.Content)
+		} else if _, cancelled := result.Err.(*FetchCancelledError); cancelled {
+			logging.Logger.WithError(result.Err).
+				WithField("correlation_id", logging.GetCorrelationId(definitionCopy.Header)).
+				Infof("cancelled fetching %v", d.URL)
+		} else if _, budgetExceeded := result.Err.(*BudgetExceededError); budgetExceeded {
+			logging.Logger.WithError(result.Err).
+				WithField("correlation_id", logging.GetCorrelationId(definitionCopy.Header)).
+				Warnf("budget exceeded on fetching %v", d.URL)
 		} else {
 			// 404 Error already become logged in logger.go
-			if fetchResult.Content == nil || fetchResult.Content.HttpStatusCode() != 404 {
-				logging.Logger.WithError(fetchResult.Err).
+			if result.Content == nil || result.Content.HttpStatusCode() != 404 {
+				logging.Logger.WithError(result.Err).
 					WithField("fetchDefinition", d).
 					WithField("correlation_id", logging.GetCorrelationId(definitionCopy.Header)).
 					Errorf("failed fetching %v", d.URL)
@@ -143,6 +335,57 @@ func (fetcher *ContentFetcher) AddFetchJob(d *FetchDefinition) {
 	}()
 }
 
+// interrupt sets the error of a result, whose fetch job was stopped by the context of the fetcher or by the budget.
+func (fetcher *ContentFetcher) interrupt(result *FetchResult) {
+	if err := fetcher.ctx.Err(); err != nil {
+		result.Err = &FetchCancelledError{URL: result.Def.URL, Cause: err}
+	} else {
+		setBudgetExceeded(result)
+	}
+}
+
+// finish sets the loaded result of a job and returns true,
+// if the job was not marked as exceeded by the budget in the meantime.
+func (fetcher *ContentFetcher) finish(fetchResult *FetchResult, result *FetchResult) bool {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+	if fetchResult.done {
+		return false
+	}
+	fetchResult.Content, fetchResult.Err, fetchResult.Stale = result.Content, result.Err, result.Stale
+	fetchResult.loaded = true
+	return true
+}
+
+// setBudgetExceeded sets the result of a job, which could not be finished within the budget.
+// The stream of a loaded content is closed, because the content is discarded.
+func setBudgetExceeded(fetchResult *FetchResult) {
+	if fetchResult.Content != nil && fetchResult.Content.Reader() != nil {
+		fetchResult.Content.Reader().Close()
+	}
+	c := NewMemoryContent()
+	c.name = fetchResult.Def.Name
+	c.httpStatusCode = http.StatusGatewayTimeout
+	fetchResult.Content = c
+	fetchResult.Err = &BudgetExceededError{URL: fetchResult.Def.URL}
+}
+
+// markDone marks the fetch result as finished and notifies the waiting callers.
+func (fetcher *ContentFetcher) markDone(fetchResult *FetchResult) {
+	fetcher.r.mutex.Lock()
+	defer fetcher.r.mutex.Unlock()
+	fetchResult.done = true
+	fetcher.r.jobDone.Broadcast()
+}
+
+// loadWithContext loads the content with the context, if the loader supports it.
+func loadWithContext(ctx context.Context, loader ContentLoader, fd *FetchDefinition) (Content, error) {
+	if contextLoader, ok := loader.(ContextContentLoader); ok {
+		return contextLoader.LoadWithContext(ctx, fd)
+	}
+	return loader.Load(fd)
+}
+
 func (fetcher *ContentFetcher) addDependentFetchJobs(content Content) {
 	for _, fetch := range content.RequiredContent() {
 		fetcher.AddFetchJob(fetch)
@@ -185,16 +428,22 @@ func (fetcher *ContentFetcher) isAlreadyScheduled(fetchDefinitionHash string) bo
 	return false
 }
 
+// MetaJSON returns a copy of the composed meta JSON object.
+// A copy is returned, because pending jobs may still add their meta data.
 func (fetcher *ContentFetcher) MetaJSON() map[string]interface{} {
 	fetcher.meta.mutex.Lock()
 	defer fetcher.meta.mutex.Unlock()
-	return fetcher.meta.json
+	metaJSON := make(map[string]interface{}, len(fetcher.meta.json))
+	for k, v := range fetcher.meta.json {
+		metaJSON[k] = v
+	}
+	return metaJSON
 }
 
 func (fetcher *ContentFetcher) expandTemplateVars(template string) (string, error) {
 	fetcher.meta.mutex.Lock()
 	defer fetcher.meta.mutex.Unlock()
-	return expandTemplateVars(template, fetcher.meta.json)
+	return expandTemplateVars(template, fetcher.meta.json, fetcher.escaping)
 }
 
 func (fetcher *ContentFetcher) addMeta(data map[string]interface{}) {
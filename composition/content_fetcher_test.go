@@ -1,9 +1,13 @@
 package composition
 
 import (
+	"context"
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -169,3 +173,227 @@ func Test_ContentFetcher_PriorityOrderAfterFetchCompletion(t *testing.T) {
 	a.Equal(1024, results[2].Def.Priority)
 
 }
+
+func Test_ContentFetcher_WaitForResultsUntil(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	loader := NewMockContentLoader(ctrl)
+	requiredFd := getFetchDefinitionMock(ctrl, loader, "/required", nil, time.Millisecond*20, map[string]interface{}{"foo": "bar"})
+	requiredFd.Required = true
+	fastFd := getFetchDefinitionMock(ctrl, loader, "/fast", nil, time.Millisecond, nil)
+	fastFd.Required = false
+	slowFd := getFetchDefinitionMock(ctrl, loader, "/slow", nil, time.Millisecond*200, map[string]interface{}{"bli": "bla"})
+	slowFd.Required = false
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+
+	fetcher.AddFetchJob(requiredFd)
+	fetcher.AddFetchJob(fastFd)
+	fetcher.AddFetchJob(slowFd)
+
+	// the required job is awaited, even if the deadline has passed
+	results, pending := fetcher.WaitForResultsUntil(time.Now())
+
+	a.Equal(2, len(results))
+	a.Equal("/required", results[0].Def.URL)
+	a.Equal("/fast", results[1].Def.URL)
+	a.Equal(1, len(pending))
+	a.Equal("/slow", pending[0].Def.URL)
+	a.Nil(fetcher.MetaJSON()["bli"])
+
+	// the pending jobs can be awaited afterwards
+	results = fetcher.WaitForResults()
+	a.Equal(3, len(results))
+	a.Equal("bla", fetcher.MetaJSON()["bli"])
+
+	// all jobs are done before the deadline
+	results, pending = fetcher.WaitForResultsUntil(time.Now().Add(time.Hour))
+	a.Equal(3, len(results))
+	a.Equal(0, len(pending))
+}
+
+func Test_ContentFetcher_Cancellation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// the parent is loaded, but its dependencies are cancelled
+	parent := NewFetchDefinition("/parent")
+	content := NewMockContent(ctrl)
+	content.EXPECT().RequiredContent().Return([]*FetchDefinition{NewFetchDefinition("/child")})
+	content.EXPECT().Meta().Return(nil)
+	content.EXPECT().Dependencies().Return(map[string]Params{"lazy": Params{}})
+
+	loader := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		if fd.URL == "/parent" {
+			cancel()
+			return content, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	fetcher := NewContentFetcherWithContext(ctx, nil)
+	fetcher.Loader = loader
+	fetcher.SetFetchDefinitionFactory(func(name string, params Params) (*FetchDefinition, bool, error) {
+		return NewFetchDefinition("/lazy").WithName(name), true, nil
+	})
+
+	fetcher.AddFetchJob(parent)
+	results := fetcher.WaitForResults()
+
+	a.Equal(3, len(results))
+	a.NoError(results[0].Err)
+	for _, res := range results[1:] {
+		a.IsType(&FetchCancelledError{}, res.Err)
+		a.True(errors.Is(res.Err, context.Canceled))
+	}
+}
+
+type contextLoaderFunc func(ctx context.Context, fd *FetchDefinition) (Content, error)
+
+func (f contextLoaderFunc) Load(fd *FetchDefinition) (Content, error) {
+	return f(context.Background(), fd)
+}
+
+func (f contextLoaderFunc) LoadWithContext(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	return f(ctx, fd)
+}
+
+func Test_ContentFetcher_Budget(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	parent := NewFetchDefinition("/parent")
+	slowChild := NewFetchDefinition("/slow-child")
+	content := NewMockContent(ctrl)
+	content.EXPECT().RequiredContent().Return([]*FetchDefinition{slowChild})
+	content.EXPECT().Meta().Return(nil)
+	content.EXPECT().Dependencies().Return(map[string]Params{})
+
+	// a loader, which needs more time than the timeout for the slow child
+	loader := contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		if fd.URL == "/parent" {
+			return content, nil
+		}
+		a.True(fd.Timeout <= time.Millisecond*50)
+		time.Sleep(fd.Timeout)
+		return NewMemoryContent(), errors.New("timeout")
+	})
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = loader
+	fetcher.SetBudget(time.Millisecond * 50)
+
+	fetcher.AddFetchJob(parent)
+	results := fetcher.WaitForResults()
+
+	a.Equal(2, len(results))
+	a.NoError(results[0].Err)
+	a.IsType(&BudgetExceededError{}, results[1].Err)
+	a.Equal(504, results[1].Content.HttpStatusCode())
+}
+
+func Test_ContentFetcher_Budget_BlockingLoader(t *testing.T) {
+	a := assert.New(t)
+
+	// a loader, which ignores the context and the timeout
+	release := make(chan struct{})
+	defer close(release)
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		_, hasDeadline := ctx.Deadline()
+		a.True(hasDeadline)
+		if fd.URL == "/fast" {
+			return NewMemoryContent(), nil
+		}
+		<-release
+		return NewMemoryContent(), nil
+	})
+	fetcher.SetBudget(time.Millisecond * 50)
+
+	fetcher.AddFetchJob(NewFetchDefinition("/fast"))
+	fetcher.AddFetchJob(NewFetchDefinition("/blocking"))
+
+	start := time.Now()
+	results := fetcher.WaitForResults()
+	a.True(time.Since(start) < time.Second)
+
+	a.Equal(2, len(results))
+	a.NoError(results[0].Err)
+	a.IsType(&BudgetExceededError{}, results[1].Err)
+	a.Equal(504, results[1].Content.HttpStatusCode())
+}
+
+// closeRecorder is a stream, which signals its closing
+type closeRecorder struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (cr *closeRecorder) Close() error {
+	close(cr.closed)
+	return nil
+}
+
+func Test_ContentFetcher_Budget_StreamOfLateContentClosed(t *testing.T) {
+	a := assert.New(t)
+
+	release := make(chan struct{})
+	stream := &closeRecorder{Reader: strings.NewReader("late"), closed: make(chan struct{})}
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		<-release
+		return &MemoryContent{reader: stream}, nil
+	})
+	fetcher.SetBudget(time.Millisecond * 10)
+
+	fetcher.AddFetchJob(NewFetchDefinition("/blocking"))
+	results := fetcher.WaitForResults()
+	a.IsType(&BudgetExceededError{}, results[0].Err)
+
+	// the content, which arrives after the budget, is discarded
+	close(release)
+	select {
+	case <-stream.closed:
+	case <-time.After(time.Second):
+		a.Fail("the stream of the discarded content is not closed")
+	}
+}
+
+func Test_ContentFetcher_Budget_Exceeded(t *testing.T) {
+	a := assert.New(t)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		a.Fail("no job should be loaded, after the budget is exceeded")
+		return nil, nil
+	})
+	fetcher.SetBudget(0)
+
+	fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+	results := fetcher.WaitForResults()
+
+	a.Equal(1, len(results))
+	a.Equal("budget exceeded on fetching /foo", results[0].Err.Error())
+}
+
+func Test_ContentFetcher_StaleContent(t *testing.T) {
+	a := assert.New(t)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		return &staleContentWrapper{Content: NewMemoryContent(), cause: errors.New("backend down")}, nil
+	})
+	fetcher.AddFetchJob(NewFetchDefinition("/foo"))
+
+	results := fetcher.WaitForResults()
+	a.NoError(results[0].Err)
+	a.Equal("backend down", results[0].Stale.Error())
+}
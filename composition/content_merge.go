@@ -1,10 +1,12 @@
 package composition
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 
 	"github.com/tarent/go-log-middleware/v2/logging"
@@ -45,6 +47,9 @@ type ContentMerge struct {
 
 	// strategy to prevent duplicacte <link rel="stylesheet"> tags
 	stylesheetDeduplicationStrategy StylesheetDeduplicationStrategy
+
+	// escaping of template variables in the fragments
+	escaping Escaping
 }
 
 // NewContentMerge creates a new buffered ContentMerge
@@ -64,6 +69,19 @@ func (cntx *ContentMerge) SetDeduplicationStrategy(strategy StylesheetDeduplicat
 	cntx.stylesheetDeduplicationStrategy = strategy
 }
 
+// SetEscaping sets the escaping of template variables. The default is EscapeHtml.
+func (cntx *ContentMerge) SetEscaping(escaping Escaping) {
+	cntx.escaping = escaping
+}
+
+// executeFragment executes the fragment with the configured escaping, if the fragment supports it.
+func (cntx *ContentMerge) executeFragment(f Fragment, w io.Writer, executeNestedFragment func(fragmentName string) error) error {
+	if ef, ok := f.(EscapingFragment); ok {
+		return ef.ExecuteWithEscaping(w, cntx.MetaJSON, executeNestedFragment, cntx.escaping)
+	}
+	return f.Execute(w, cntx.MetaJSON, executeNestedFragment)
+}
+
 func (cntx *ContentMerge) collectStylesheets(f Fragment) {
 	cntx.stylesheets = append(cntx.stylesheets, f.Stylesheets()...)
 }
@@ -94,7 +112,7 @@ func generateExecutionFunction(cntx *ContentMerge, w io.Writer) (executeFragment
 			return errors.New(missingFragmentString)
 		}
 		cntx.collectStylesheets(f)
-		return f.Execute(w, cntx.MetaJSON, executeFragment)
+		return cntx.executeFragment(f, w, executeFragment)
 	}
 	return executeFragment
 }
@@ -141,7 +159,7 @@ func (cntx *ContentMerge) GetHtml() ([]byte, error) {
 	for _, f := range cntx.Head {
 		cntx.collectStylesheets(f)
 		executeFragment := generateExecutionFunction(cntx, header)
-		if err := f.Execute(header, cntx.MetaJSON, executeFragment); err != nil {
+		if err := cntx.executeFragment(f, header, executeFragment); err != nil {
 			return nil, err
 		}
 	}
@@ -152,20 +170,15 @@ func (cntx *ContentMerge) GetHtml() ([]byte, error) {
 	io.WriteString(body, collectBodyAttrs(cntx.BodyAttrsArray))
 	io.WriteString(body, ">\n    ")
 
-	startFragmentName := ""
-	if _, exist := cntx.GetBodyFragmentByName(LayoutFragmentName); exist {
-		startFragmentName = LayoutFragmentName
-	}
-
 	// recursively process body fragments
 	executeFragment := generateExecutionFunction(cntx, body)
-	if err := executeFragment(startFragmentName); err != nil {
+	if err := executeFragment(cntx.startFragmentName()); err != nil {
 		return nil, err
 	}
 
 	for _, f := range cntx.Tail {
 		cntx.collectStylesheets(f)
-		if err := f.Execute(body, cntx.MetaJSON, executeFragment); err != nil {
+		if err := cntx.executeFragment(f, body, executeFragment); err != nil {
 			return nil, err
 		}
 	}
@@ -180,6 +193,318 @@ func (cntx *ContentMerge) GetHtml() ([]byte, error) {
 	return html, nil
 }
 
+// WriteHtml writes the html to w, while it is rendered.
+// The head is written and flushed, before the body is rendered, if w implements http.Flusher.
+// So the browser can start loading the stylesheets early.
+//
+// Because the head is written first, the stylesheets are collected in advance
+// out of all fragments reachable by includes (see IncludingFragment).
+// Stylesheets of fragments, which were not found in advance, are written in front of the fragment in the body.
+//
+// Errors within the head or a missing start fragment are returned before anything is written.
+// Errors within the body are returned after a part of the html is already written.
+func (cntx *ContentMerge) WriteHtml(w io.Writer) error {
+	return cntx.writeHtml(w, nil)
+}
+
+// WriteHtmlProgressive writes the html like WriteHtml(), without waiting for the pending contents.
+// The optional includes of fragments out of pending contents are rendered as placeholders,
+// containing the alternative content. After the tail, waitForPending is called and the fragments
+// of the arrived contents are written at the end of the body, together with a script,
+// which moves them into their placeholders. If a fragment is still not available, the alternative content is moved instead.
+// The heads and tails of the arrived contents are also written at the end of the body, because the head is already sent.
+// Required includes of fragments out of pending contents block the rendering, until the pending contents have arrived.
+func (cntx *ContentMerge) WriteHtmlProgressive(w io.Writer, pendingContentNames []string, waitForPending func() []Content) error {
+	pending := &pendingContents{
+		names:          make(map[string]bool),
+		waitForPending: waitForPending,
+	}
+	for _, name := range pendingContentNames {
+		pending.names[name] = true
+	}
+	return cntx.writeHtml(w, pending)
+}
+
+func (cntx *ContentMerge) writeHtml(w io.Writer, pending *pendingContents) error {
+	if len(cntx.priorities) > 0 {
+		cntx.processMetaPriorityParsing()
+	}
+
+	startFragmentName := cntx.startFragmentName()
+	if _, exist := cntx.GetBodyFragmentByName(startFragmentName); !exist {
+		return errors.New(generateMissingFragmentString(cntx.Body, startFragmentName))
+	}
+
+	// collect the stylesheets in advance
+	visited := map[Fragment]bool{}
+	for _, f := range cntx.Head {
+		cntx.collectReachableStylesheets(f, visited)
+	}
+	if f, exist := cntx.GetBodyFragmentByName(startFragmentName); exist {
+		cntx.collectReachableStylesheets(f, visited)
+	}
+	for _, f := range cntx.Tail {
+		cntx.collectReachableStylesheets(f, visited)
+	}
+
+	// render the head into a buffer, to be able to return errors before writing
+	header := bytes.NewBuffer(make([]byte, 0, DefaultBufferSize))
+	io.WriteString(header, "<!DOCTYPE html>\n<html>\n  <head>\n    ")
+
+	writtenStylesheets := map[string]bool{}
+	for _, attrs := range cntx.stylesheets {
+		writtenStylesheets[joinAttrs(attrs)] = true
+	}
+	headExecuteFragment := generateStreamingExecutionFunction(cntx, header, writtenStylesheets, pending)
+	for _, f := range cntx.Head {
+		if err := cntx.executeFragment(f, header, headExecuteFragment); err != nil {
+			return err
+		}
+	}
+	cntx.writeStylesheets(header)
+	io.WriteString(header, "\n  </head>")
+
+	io.WriteString(header, "\n  <body")
+	io.WriteString(header, collectBodyAttrs(cntx.BodyAttrsArray))
+	io.WriteString(header, ">\n    ")
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	flush(w)
+
+	// recursively process body fragments
+	body := bufio.NewWriter(w)
+	executeFragment := generateStreamingExecutionFunction(cntx, body, writtenStylesheets, pending)
+	if err := executeFragment(startFragmentName); err != nil {
+		body.Flush()
+		return err
+	}
+
+	for _, f := range cntx.Tail {
+		writeNewStylesheets(body, f, writtenStylesheets)
+		if err := cntx.executeFragment(f, body, executeFragment); err != nil {
+			body.Flush()
+			return err
+		}
+	}
+
+	if pending != nil && (len(pending.placeholders) > 0 || len(pending.heads) > 0 || len(pending.tails) > 0) {
+		if err := body.Flush(); err != nil {
+			return err
+		}
+		flush(w)
+		if err := cntx.writePendingFragments(w, pending, writtenStylesheets); err != nil {
+			return err
+		}
+	}
+
+	io.WriteString(body, "\n  </body>\n</html>\n")
+
+	return body.Flush()
+}
+
+// pendingContents holds the state of the progressive rendering
+type pendingContents struct {
+	// the names of the contents, which are still loading
+	names map[string]bool
+
+	// blocks until all pending contents are loaded and returns the successfully loaded ones
+	waitForPending func() []Content
+
+	// the placeholders for fragments to write later
+	placeholders []pendingPlaceholder
+
+	// the head and tail fragments of the arrived contents, which are written at the end of the body
+	heads []Fragment
+	tails []Fragment
+}
+
+type pendingPlaceholder struct {
+	id           string
+	fragmentName string
+
+	// fallback writes the alternative content of the placeholder, if the fragment can not be rendered
+	fallback func(w io.Writer, executeNestedFragment func(fragmentName string) error) error
+}
+
+// isPending returns true, if the fragment may be contained in one of the pending contents.
+// Local fragment names (e.g. '#content') can not be assigned to a content and are never pending.
+func (pending *pendingContents) isPending(fragmentName string) bool {
+	if pending == nil {
+		return false
+	}
+	contentName := strings.Split(fragmentName, FragmentSeparater)[0]
+	return pending.names[contentName]
+}
+
+// wait waits for the pending contents and adds them to the merge.
+func (pending *pendingContents) wait(cntx *ContentMerge) {
+	if len(pending.names) == 0 {
+		return
+	}
+	pending.names = map[string]bool{}
+	for _, c := range pending.waitForPending() {
+		cntx.addPendingContent(c)
+		if c.Head() != nil {
+			pending.heads = append(pending.heads, c.Head())
+		}
+		if c.Tail() != nil {
+			pending.tails = append(pending.tails, c.Tail())
+		}
+	}
+}
+
+// addPendingContent adds the body fragments and meta data of a content,
+// which arrived after the rendering has started.
+func (cntx *ContentMerge) addPendingContent(c Content) {
+	cntx.addBody(c)
+	if cntx.MetaJSON == nil {
+		cntx.MetaJSON = make(map[string]interface{})
+	}
+	for k, v := range c.Meta() {
+		cntx.MetaJSON[k] = v
+	}
+}
+
+// pendingFragmentScript defines the function, which moves a pending fragment out of its template into the placeholder
+const pendingFragmentScript = `
+    <script>function uicReplacePending(id){` +
+	`var p=document.getElementById(id),t=document.getElementById(id+"-content");` +
+	`if(p&&t){p.parentNode.replaceChild(document.importNode(t.content,true),p);t.parentNode.removeChild(t);}}</script>`
+
+// writePendingFragments waits for the pending contents and writes the fragments of the placeholders.
+// The heads of the arrived contents are written before and their tails after the fragments.
+// Fragments, which are still missing or fail in execution are replaced by the alternative content of their placeholder,
+// or by nothing, if this fails, too. Placeholders, which are added while writing the fragments, are written as well.
+// Each fragment is written as one chunk to w, which is flushed afterwards, if it implements http.Flusher.
+func (cntx *ContentMerge) writePendingFragments(w io.Writer, pending *pendingContents, writtenStylesheets map[string]bool) error {
+	pending.wait(cntx)
+
+	chunk := bytes.NewBuffer(nil)
+	for _, f := range pending.heads {
+		cntx.writeLateFragment(chunk, f, writtenStylesheets, pending)
+	}
+
+	if len(pending.placeholders) > 0 {
+		io.WriteString(chunk, pendingFragmentScript)
+	}
+	for i := 0; i < len(pending.placeholders); i++ {
+		placeholder := pending.placeholders[i]
+		fragment := bytes.NewBuffer(nil)
+		executeFragment := generateStreamingExecutionFunction(cntx, fragment, writtenStylesheets, pending)
+		if err := executeFragment(placeholder.fragmentName); err != nil {
+			logging.Logger.WithError(err).Warnf("pending fragment not rendered, using the alternative content: %v", placeholder.fragmentName)
+			fragment.Reset()
+			if err := placeholder.fallback(fragment, executeFragment); err != nil {
+				logging.Logger.WithError(err).Warnf("alternative content of pending fragment not rendered: %v", placeholder.fragmentName)
+				fragment.Reset()
+			}
+		}
+		fmt.Fprintf(chunk, "\n    <template id=\"%s-content\">", placeholder.id)
+		chunk.Write(fragment.Bytes())
+		fmt.Fprintf(chunk, "</template><script>uicReplacePending(\"%s\")</script>", placeholder.id)
+		if _, err := w.Write(chunk.Bytes()); err != nil {
+			return err
+		}
+		flush(w)
+		chunk.Reset()
+	}
+
+	for _, f := range pending.tails {
+		cntx.writeLateFragment(chunk, f, writtenStylesheets, pending)
+	}
+	_, err := w.Write(chunk.Bytes())
+	return err
+}
+
+// writeLateFragment writes a head or tail fragment of an arrived content together with its new stylesheets.
+// Fragments, which fail in execution are skipped.
+func (cntx *ContentMerge) writeLateFragment(w io.Writer, f Fragment, writtenStylesheets map[string]bool, pending *pendingContents) {
+	chunk := bytes.NewBuffer(nil)
+	writeNewStylesheets(chunk, f, writtenStylesheets)
+	executeFragment := generateStreamingExecutionFunction(cntx, chunk, writtenStylesheets, pending)
+	if err := cntx.executeFragment(f, chunk, executeFragment); err != nil {
+		logging.Logger.WithError(err).Warn("head or tail of a pending content not rendered")
+		return
+	}
+	w.Write(chunk.Bytes())
+}
+
+// flush flushes the writer, if it supports flushing
+func flush(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// generateStreamingExecutionFunction returns a function for the execution of nested fragments,
+// which writes the stylesheets of the fragments, which were not written before.
+// If the fragment is missing, but may be part of a pending content, a pendingFragmentError is returned.
+func generateStreamingExecutionFunction(cntx *ContentMerge, w io.Writer, writtenStylesheets map[string]bool, pending *pendingContents) (executeFragment func(fragmentName string) error) {
+	executeFragment = func(fragmentName string) error {
+		f, exist := cntx.GetBodyFragmentByName(fragmentName)
+		if !exist {
+			if pending.isPending(fragmentName) {
+				return &pendingFragmentError{
+					fragmentName: fragmentName,
+					addPlaceholder: func(fallback func(w io.Writer, executeNestedFragment func(fragmentName string) error) error) string {
+						id := fmt.Sprintf("%s-%d", PendingPlaceholderElement, len(pending.placeholders)+1)
+						pending.placeholders = append(pending.placeholders, pendingPlaceholder{id: id, fragmentName: fragmentName, fallback: fallback})
+						return id
+					},
+					wait: func() {
+						pending.wait(cntx)
+					},
+				}
+			}
+			missingFragmentString := generateMissingFragmentString(cntx.Body, fragmentName)
+			return errors.New(missingFragmentString)
+		}
+		writeNewStylesheets(w, f, writtenStylesheets)
+		return cntx.executeFragment(f, w, executeFragment)
+	}
+	return executeFragment
+}
+
+// writeNewStylesheets writes those stylesheets of the fragment, which are not yet written
+func writeNewStylesheets(w io.Writer, f Fragment, writtenStylesheets map[string]bool) {
+	for _, attrs := range f.Stylesheets() {
+		joinedAttr := joinAttrs(attrs)
+		if !writtenStylesheets[joinedAttr] {
+			writtenStylesheets[joinedAttr] = true
+			fmt.Fprintf(w, "<link %s>", joinedAttr)
+		}
+	}
+}
+
+// collectReachableStylesheets collects the stylesheets of the fragment
+// and recursively of all fragments, which are included by it.
+func (cntx *ContentMerge) collectReachableStylesheets(f Fragment, visited map[Fragment]bool) {
+	if visited[f] {
+		return
+	}
+	visited[f] = true
+	cntx.collectStylesheets(f)
+
+	if includingFragment, ok := f.(IncludingFragment); ok {
+		for _, name := range includingFragment.Includes() {
+			if included, exist := cntx.GetBodyFragmentByName(name); exist {
+				cntx.collectReachableStylesheets(included, visited)
+			}
+		}
+	}
+}
+
+// startFragmentName returns the name of the fragment to start the rendering of the body with.
+// This is the layout fragment, if there is one, or the default fragment otherwise.
+func (cntx *ContentMerge) startFragmentName() string {
+	if _, exist := cntx.GetBodyFragmentByName(LayoutFragmentName); exist {
+		return LayoutFragmentName
+	}
+	return ""
+}
+
 // GetBodyFragmentByName returns a fragment by ists name.
 // If the name does not contain a FragmentSeparater ('#'), and no such fragment is found.
 // also a lookup for '#name' is done, to check, if there is a local name matching.
@@ -1,10 +1,13 @@
 package composition
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"strings"
 	"testing"
 
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/html"
 )
@@ -275,6 +278,311 @@ func Test_ContentMerge_LookupByDifferentFragmentNames(t *testing.T) {
 	a.Equal(fragmentB, f)
 }
 
+func Test_ContentMerge_Escaping(t *testing.T) {
+	a := assert.New(t)
+
+	newMerge := func() *ContentMerge {
+		cm := NewContentMerge(map[string]interface{}{"q": "<script>"})
+		cm.AddContent(&MemoryContent{
+			name: "main",
+			body: map[string]Fragment{
+				"": NewStringFragment("§[ q ]§"),
+			}}, 0)
+		return cm
+	}
+
+	html, err := newMerge().GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), "&lt;script&gt;")
+
+	cm := newMerge()
+	cm.SetEscaping(EscapeNone)
+	html, err = cm.GetHtml()
+	a.NoError(err)
+	a.Contains(string(html), "<script>")
+}
+
+func Test_ContentMerge_WriteHtml(t *testing.T) {
+	a := assert.New(t)
+
+	layout := NewStringFragment("<main>§[> content]§</main>§[#> missing]§§[/missing]§")
+	layout.AddStylesheets([][]html.Attribute{stylesheetAttrs("/layout.css")})
+	content := NewStringFragment("§[ text ]§")
+	content.AddStylesheets([][]html.Attribute{stylesheetAttrs("/content.css"), stylesheetAttrs("/layout.css")})
+	unused := NewStringFragment("unused")
+	unused.AddStylesheets([][]html.Attribute{stylesheetAttrs("/unused.css")})
+
+	cm := NewContentMerge(map[string]interface{}{"text": "Hello"})
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		head: NewStringFragment("<title>Hello</title>"),
+		body: map[string]Fragment{
+			"layout":  layout,
+			"content": content,
+			"unused":  unused,
+		},
+		tail: NewStringFragment("<script></script>"),
+	}, 0)
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtml(buf)
+	a.NoError(err)
+
+	expected := `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Hello</title>
+    <link rel="stylesheet" type="text/css" href="/layout.css">
+    <link rel="stylesheet" type="text/css" href="/content.css">
+    <link rel="stylesheet" type="text/css" href="/layout.css">
+  </head>
+  <body>
+    <main>Hello</main><script></script>
+  </body>
+</html>
+`
+	a.Equal(expected, buf.String())
+}
+
+func Test_ContentMerge_WriteHtml_StylesheetsOfNotReachableFragments(t *testing.T) {
+	a := assert.New(t)
+
+	// a fragment, which does not tell its includes
+	layout := NewMockFragment(gomock.NewController(t))
+	layout.EXPECT().Stylesheets().Return(nil).AnyTimes()
+	layout.EXPECT().Execute(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+			return executeNestedFragment("content")
+		})
+	content := NewStringFragment("content")
+	content.AddStylesheets([][]html.Attribute{stylesheetAttrs("/content.css")})
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"layout":  layout,
+			"content": content,
+		},
+	}, 0)
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtml(buf)
+	a.NoError(err)
+	a.Contains(buf.String(), `<body>
+    <link rel="stylesheet" type="text/css" href="/content.css">content`)
+}
+
+func Test_ContentMerge_WriteHtml_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	buf := bytes.NewBuffer(nil)
+	err := NewContentMerge(nil).WriteHtml(buf)
+	a.Error(err)
+	a.Equal("Fragment does not exist: . Existing fragments: ", err.Error())
+	a.Equal(0, buf.Len())
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("before §[> missing]§"),
+		},
+	}, 0)
+	err = cm.WriteHtml(buf)
+	a.Error(err)
+	a.Contains(buf.String(), "<body>\n    before ")
+}
+
+func Test_ContentMerge_WriteHtmlProgressive(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("<main>§[#> slow#teaser]§loading§[/slow#teaser]§</main>§[#> other]§§[/other]§"),
+		},
+		tail: NewStringFragment("<script></script>"),
+	}, 0)
+
+	waited := 0
+	waitForPending := func() []Content {
+		waited++
+		return []Content{&MemoryContent{
+			name: "slow",
+			meta: map[string]interface{}{"text": "Hello"},
+			body: map[string]Fragment{
+				"teaser": NewStringFragment("<p>§[ text ]§</p>"),
+			},
+		}}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtmlProgressive(buf, []string{"slow"}, waitForPending)
+	a.NoError(err)
+	a.Equal(1, waited)
+
+	html := buf.String()
+	a.Contains(html, `<main><uic-pending id="uic-pending-1">loading</uic-pending></main><script></script>`)
+	a.Contains(html, `function uicReplacePending(id)`)
+	a.Contains(html, `<template id="uic-pending-1-content"><p>Hello</p></template><script>uicReplacePending("uic-pending-1")</script>`)
+	a.True(strings.HasSuffix(html, "</script>\n  </body>\n</html>\n"))
+}
+
+// flushRecorder records the written html at each flush
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.flushed = append(fr.flushed, fr.String())
+}
+
+func Test_ContentMerge_WriteHtmlProgressive_FlushesEachFragment(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("§[#> slow#a]§a§[/slow#a]§ §[#> slow#b]§b§[/slow#b]§"),
+		},
+	}, 0)
+
+	waitForPending := func() []Content {
+		return []Content{&MemoryContent{
+			name: "slow",
+			body: map[string]Fragment{
+				"a": NewStringFragment("late a"),
+				"b": NewStringFragment("late b"),
+			},
+		}}
+	}
+
+	w := &flushRecorder{}
+	err := cm.WriteHtmlProgressive(w, []string{"slow"}, waitForPending)
+	a.NoError(err)
+
+	// the head, the body before the late fragments and each late fragment are flushed
+	if a.Equal(4, len(w.flushed)) {
+		a.True(strings.HasSuffix(w.flushed[1], `<uic-pending id="uic-pending-2">b</uic-pending>`))
+		a.True(strings.HasSuffix(w.flushed[2], `late a</template><script>uicReplacePending("uic-pending-1")</script>`))
+		a.True(strings.HasSuffix(w.flushed[3], `late b</template><script>uicReplacePending("uic-pending-2")</script>`))
+	}
+}
+
+func Test_ContentMerge_WriteHtmlProgressive_PendingContentMissing(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("§[#> slow]§loading§[/slow]§"),
+		},
+	}, 0)
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtmlProgressive(buf, []string{"slow"}, func() []Content { return nil })
+	a.NoError(err)
+
+	// the alternative content replaces the placeholder
+	a.Contains(buf.String(), `<uic-pending id="uic-pending-1">loading</uic-pending>`)
+	a.Contains(buf.String(), `<template id="uic-pending-1-content">loading</template><script>uicReplacePending("uic-pending-1")</script>`)
+}
+
+func Test_ContentMerge_WriteHtmlProgressive_NestedPendingFragments(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("<main>§[#> slow#teaser]§loading§[/slow#teaser]§</main>"),
+		},
+	}, 0)
+
+	// the late teaser includes a fragment of another late content
+	waitForPending := func() []Content {
+		return []Content{
+			&MemoryContent{
+				name: "slow",
+				head: NewStringFragment(`<meta name="slow">`),
+				body: map[string]Fragment{
+					"teaser": NewStringFragment("<p>§[#> slower#price]§no price§[/slower#price]§ §[#> missing]§no missing§[/missing]§</p>"),
+				},
+				tail: NewStringFragment(`<script src="/slow.js"></script>`),
+			},
+			&MemoryContent{
+				name: "slower",
+				body: map[string]Fragment{
+					"price": NewStringFragment("<b>42</b>"),
+				},
+			},
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtmlProgressive(buf, []string{"slow", "slower"}, waitForPending)
+	a.NoError(err)
+
+	html := buf.String()
+	a.Contains(html, `<template id="uic-pending-1-content"><p><b>42</b> no missing</p></template>`)
+	a.True(strings.Index(html, `<meta name="slow">`) < strings.Index(html, `<template id="uic-pending-1-content">`))
+	a.True(strings.Index(html, `<script src="/slow.js"></script>`) > strings.Index(html, `uicReplacePending("uic-pending-1")</script>`))
+}
+
+func Test_ContentMerge_WriteHtmlProgressive_AllPlaceholdersWritten(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("§[#> slow]§§[#> slow#nested]§loading§[/slow#nested]§§[/slow]§"),
+		},
+	}, 0)
+
+	// the alternative content of a placeholder contains another placeholder, both are replaced
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtmlProgressive(buf, []string{"slow"}, func() []Content { return nil })
+	a.NoError(err)
+
+	html := buf.String()
+	a.Contains(html, `<uic-pending id="uic-pending-1"><uic-pending id="uic-pending-2">loading</uic-pending></uic-pending>`)
+	a.Contains(html, `uicReplacePending("uic-pending-1")`)
+	a.Contains(html, `uicReplacePending("uic-pending-2")`)
+}
+
+func Test_ContentMerge_WriteHtmlProgressive_RequiredIncludeWaits(t *testing.T) {
+	a := assert.New(t)
+
+	cm := NewContentMerge(nil)
+	cm.AddContent(&MemoryContent{
+		name: "example.com",
+		body: map[string]Fragment{
+			"": NewStringFragment("<main>§[> slow]§</main>"),
+		},
+	}, 0)
+
+	waitForPending := func() []Content {
+		return []Content{&MemoryContent{
+			name: "slow",
+			body: map[string]Fragment{
+				"": NewStringFragment("slow content"),
+			},
+		}}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := cm.WriteHtmlProgressive(buf, []string{"slow"}, waitForPending)
+	a.NoError(err)
+	a.Contains(buf.String(), "<main>slow content</main>")
+	a.NotContains(buf.String(), PendingPlaceholderElement)
+}
+
 func Test_GenerateMissingFragmentString(t *testing.T) {
 	body := map[string]Fragment{
 		"footer": nil,
@@ -0,0 +1,182 @@
+package composition
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/html"
+	"net/http"
+	"time"
+)
+
+// ContentSerializer converts the objects, which are cached by the CachingContentLoader, to JSON and back,
+// e.g. for a cache.DiskCache. These are MemoryContents with StringFragments, buffered streams and markers of varying responses.
+// Contents with other fragment types, or with required contents, which use a service discovery, are not serializable.
+type ContentSerializer struct {
+}
+
+func NewContentSerializer() *ContentSerializer {
+	return &ContentSerializer{}
+}
+
+const (
+	contentRecordMemory = "memory"
+	contentRecordStream = "stream"
+	contentRecordVary   = "vary"
+)
+
+// contentRecord is the serialized form of a cached object.
+type contentRecord struct {
+	Type            string                     `json:"type"`
+	Name            string                     `json:"name,omitempty"`
+	RequiredContent []*fetchDefinitionRecord   `json:"required_content,omitempty"`
+	Dependencies    map[string]Params          `json:"dependencies,omitempty"`
+	Meta            map[string]interface{}     `json:"meta,omitempty"`
+	Head            *fragmentRecord            `json:"head,omitempty"`
+	Body            map[string]*fragmentRecord `json:"body,omitempty"`
+	Tail            *fragmentRecord            `json:"tail,omitempty"`
+	BodyAttributes  []html.Attribute           `json:"body_attributes,omitempty"`
+	HttpHeader      http.Header                `json:"http_header,omitempty"`
+	HttpStatusCode  int                        `json:"http_status_code,omitempty"`
+	Stream          []byte                     `json:"stream,omitempty"`
+	VaryHeaders     []string                   `json:"vary_headers,omitempty"`
+}
+
+type fragmentRecord struct {
+	Content     string             `json:"content"`
+	Stylesheets [][]html.Attribute `json:"stylesheets,omitempty"`
+}
+
+// fetchDefinitionRecord holds the attributes of fetch definitions, which are set by the HtmlContentParser.
+type fetchDefinitionRecord struct {
+	Name     string        `json:"name"`
+	URL      string        `json:"url"`
+	Timeout  time.Duration `json:"timeout"`
+	Required bool          `json:"required"`
+}
+
+func (s *ContentSerializer) Serialize(cacheObject interface{}) ([]byte, error) {
+	var record *contentRecord
+	var err error
+	switch o := cacheObject.(type) {
+	case *MemoryContent:
+		record, err = memoryContentRecord(o)
+	case *ContentWrapper:
+		memoryContent, isMemoryContent := o.Content.(*MemoryContent)
+		if !isMemoryContent {
+			return nil, fmt.Errorf("content of type %T not serializable", o.Content)
+		}
+		if record, err = memoryContentRecord(memoryContent); err == nil {
+			record.Type = contentRecordStream
+			record.Stream = o.streamBytes
+		}
+	case *varyMarker:
+		record = &contentRecord{Type: contentRecordVary, VaryHeaders: o.varyHeaders}
+	default:
+		return nil, fmt.Errorf("object of type %T not serializable", cacheObject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+func (s *ContentSerializer) Deserialize(data []byte) (interface{}, error) {
+	record := &contentRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	switch record.Type {
+	case contentRecordMemory:
+		return record.memoryContent(), nil
+	case contentRecordStream:
+		return &ContentWrapper{Content: record.memoryContent(), streamBytes: record.Stream}, nil
+	case contentRecordVary:
+		return &varyMarker{varyHeaders: record.VaryHeaders}, nil
+	}
+	return nil, fmt.Errorf("unknown type of serialized content: %q", record.Type)
+}
+
+func memoryContentRecord(c *MemoryContent) (*contentRecord, error) {
+	record := &contentRecord{
+		Type:           contentRecordMemory,
+		Name:           c.name,
+		Dependencies:   c.dependencies,
+		Meta:           c.meta,
+		Body:           make(map[string]*fragmentRecord, len(c.body)),
+		BodyAttributes: c.bodyAttributes,
+		HttpHeader:     c.httpHeader,
+		HttpStatusCode: c.httpStatusCode,
+	}
+	for _, fd := range c.requiredContent {
+		if fd.ServiceDiscoveryActive {
+			return nil, fmt.Errorf("required content %v with service discovery not serializable", fd.URL)
+		}
+		record.RequiredContent = append(record.RequiredContent, &fetchDefinitionRecord{
+			Name:     fd.Name,
+			URL:      fd.URL,
+			Timeout:  fd.Timeout,
+			Required: fd.Required,
+		})
+	}
+
+	var err error
+	if record.Head, err = newFragmentRecord(c.head); err != nil {
+		return nil, err
+	}
+	if record.Tail, err = newFragmentRecord(c.tail); err != nil {
+		return nil, err
+	}
+	for name, f := range c.body {
+		if record.Body[name], err = newFragmentRecord(f); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func newFragmentRecord(f Fragment) (*fragmentRecord, error) {
+	if f == nil {
+		return nil, nil
+	}
+	stringFragment, isStringFragment := f.(*StringFragment)
+	if !isStringFragment {
+		return nil, fmt.Errorf("fragment of type %T not serializable", f)
+	}
+	return &fragmentRecord{Content: stringFragment.content, Stylesheets: stringFragment.stylesheets}, nil
+}
+
+func (record *contentRecord) memoryContent() *MemoryContent {
+	c := NewMemoryContent()
+	c.name = record.Name
+	for _, fd := range record.RequiredContent {
+		c.requiredContent[fd.URL] = &FetchDefinition{
+			Name:     fd.Name,
+			URL:      fd.URL,
+			Timeout:  fd.Timeout,
+			Required: fd.Required,
+		}
+	}
+	if record.Dependencies != nil {
+		c.dependencies = record.Dependencies
+	}
+	if record.Meta != nil {
+		c.meta = record.Meta
+	}
+	c.head = record.Head.stringFragment()
+	c.tail = record.Tail.stringFragment()
+	for name, f := range record.Body {
+		c.body[name] = f.stringFragment()
+	}
+	c.bodyAttributes = record.BodyAttributes
+	c.httpHeader = record.HttpHeader
+	c.httpStatusCode = record.HttpStatusCode
+	return c
+}
+
+// stringFragment returns the fragment of the record, or nil for an empty record.
+func (record *fragmentRecord) stringFragment() Fragment {
+	if record == nil {
+		return nil
+	}
+	return &StringFragment{content: record.Content, stylesheets: record.Stylesheets}
+}
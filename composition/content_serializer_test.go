@@ -0,0 +1,79 @@
+package composition
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ContentSerializer_MemoryContent(t *testing.T) {
+	a := assert.New(t)
+
+	c := NewMemoryContent()
+	c.name = "teaser"
+	c.requiredContent["http://example.de/prices"] = &FetchDefinition{Name: "prices", URL: "http://example.de/prices", Timeout: time.Second, Required: true}
+	c.dependencies["prices"] = Params{"id": "42"}
+	c.meta["title"] = "Teaser"
+	c.head = NewStringFragment("<title>§[ title ]§</title>")
+	bodyFragment := NewStringFragment("<div>teaser</div>")
+	bodyFragment.AddStylesheets([][]html.Attribute{{{Key: "href", Val: "/teaser.css"}}})
+	c.body[""] = bodyFragment
+	c.tail = NewStringFragment("<script></script>")
+	c.bodyAttributes = []html.Attribute{{Key: "class", Val: "teaser"}}
+	c.httpHeader = http.Header{"Cache-Control": {"max-age=30"}}
+	c.httpStatusCode = 200
+
+	serializer := NewContentSerializer()
+	data, err := serializer.Serialize(c)
+	a.NoError(err)
+
+	o, err := serializer.Deserialize(data)
+	a.NoError(err)
+	a.Equal(c, o)
+}
+
+func Test_ContentSerializer_StreamAndVaryMarker(t *testing.T) {
+	a := assert.New(t)
+	serializer := NewContentSerializer()
+
+	c := NewMemoryContent()
+	c.httpStatusCode = 200
+	data, err := serializer.Serialize(&ContentWrapper{Content: c, streamBytes: []byte("foobar")})
+	a.NoError(err)
+	o, err := serializer.Deserialize(data)
+	a.NoError(err)
+	stream, _ := ioutil.ReadAll(o.(Content).Reader())
+	a.Equal("foobar", string(stream))
+	a.Equal(200, o.(Content).HttpStatusCode())
+
+	data, err = serializer.Serialize(&varyMarker{varyHeaders: []string{"Accept-Language"}})
+	a.NoError(err)
+	o, err = serializer.Deserialize(data)
+	a.NoError(err)
+	a.Equal(&varyMarker{varyHeaders: []string{"Accept-Language"}}, o)
+}
+
+func Test_ContentSerializer_NotSerializable(t *testing.T) {
+	a := assert.New(t)
+	serializer := NewContentSerializer()
+
+	_, err := serializer.Serialize("foo")
+	a.Error(err)
+
+	c := NewMemoryContent()
+	c.body[""] = NewMockFragment(nil)
+	_, err = serializer.Serialize(c)
+	a.Error(err)
+
+	c = NewMemoryContent()
+	c.requiredContent["http://service/"] = &FetchDefinition{URL: "http://service/", ServiceDiscoveryActive: true}
+	_, err = serializer.Serialize(c)
+	a.Error(err)
+
+	_, err = serializer.Deserialize([]byte(`{"type": "foo"}`))
+	a.True(strings.Contains(err.Error(), "unknown type"))
+}
@@ -53,6 +53,13 @@ var ForwardResponseHeaders = []string{
 	"Set-Cookie",
 	"WWW-Authenticate"}
 
+// SurrogateKeyHeaders are those response headers, which contain the surrogate keys or tags of a response,
+// separated by spaces or commas. Cached contents can be purged by these tags.
+var SurrogateKeyHeaders = []string{
+	"Surrogate-Key",
+	"Cache-Tag",
+}
+
 const (
 	DefaultTimeout  time.Duration = 10 * time.Second
 	FileURLPrefix                 = "file://"
@@ -76,6 +83,8 @@ type FetchDefinition struct {
 	ServiceDiscoveryActive bool
 	ServiceDiscovery       servicediscovery.ServiceDiscovery
 	Priority               int
+	Retry                  *RetryPolicy
+	HedgeDelay             time.Duration
 }
 
 // Creates a fetch definition (warning: this one will not forward any request headers).
@@ -133,6 +142,21 @@ func (fd *FetchDefinition) WithResponseProcessor(rp ResponseProcessor) *FetchDef
 	return fd
 }
 
+// WithRetry sets the policy for retries of failed fetches.
+// Only idempotent methods are retried, unless the policy allows RetryNonIdempotent.
+func (fd *FetchDefinition) WithRetry(policy *RetryPolicy) *FetchDefinition {
+	fd.Retry = policy
+	return fd
+}
+
+// WithHedging enables hedged requests: If the request has not been answered within the delay,
+// a second identical request is sent and the first response is taken.
+// Only GET and HEAD requests are hedged.
+func (fd *FetchDefinition) WithHedging(delay time.Duration) *FetchDefinition {
+	fd.HedgeDelay = delay
+	return fd
+}
+
 // Set a name to be used in the merge context later on
 func (fd *FetchDefinition) WithName(name string) *FetchDefinition {
 	fd.Name = name
@@ -1,6 +1,7 @@
 package composition
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tarent/go-log-middleware/v2/logging"
@@ -23,6 +24,15 @@ func NewFileContentLoader() *FileContentLoader {
 }
 
 func (loader *FileContentLoader) Load(fd *FetchDefinition) (Content, error) {
+	return loader.LoadWithContext(context.Background(), fd)
+}
+
+// LoadWithContext loads the file, if the context is not done.
+// Reading a file is not interrupted by the context.
+func (loader *FileContentLoader) LoadWithContext(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if fd.RespProc != nil {
 		return nil, ResponseProcessorsNotApplicable
 	}
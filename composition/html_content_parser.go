@@ -18,6 +18,9 @@ const (
 	UicFetch        = "uic-fetch"
 	UicFragment     = "uic-fragment"
 	UicTail         = "uic-tail"
+	UicIf           = "uic-if"
+	UicElse         = "uic-else"
+	UicIfCondition  = "cond"
 	ScriptTypeMeta  = "text/uic-meta"
 	ParamAttrPrefix = "param-"
 )
@@ -96,6 +99,9 @@ forloop:
 	if len(st) > 0 || len(stylesheets) > 0 {
 		frg := NewStringFragment(st)
 		frg.AddStylesheets(stylesheets)
+		if err := frg.Compile(); err != nil {
+			return err
+		}
 		c.head = frg
 	}
 	return nil
@@ -170,17 +176,25 @@ forloop:
 				}
 			}
 			if string(tag) == UicInclude {
-				if replaceTextStart, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, attrs); err != nil {
+				if deps, includeStylesheets, err := parseInclude(z, tt, attrs, bodyBuff); err != nil {
 					return err
 				} else {
-					c.dependencies[dependencyName] = dependencyParams
-					bodyBuff.WriteString(replaceTextStart)
-					// Enhancement: WriteOut sub tree, to allow alternative content
-					//              for optional includes.
-					bodyBuff.WriteString(replaceTextEnd)
+					for depName, depParams := range deps {
+						c.dependencies[depName] = depParams
+					}
+					stylesheets = append(stylesheets, includeStylesheets...)
 					continue
 				}
 			}
+			if handled, deps, condStylesheets, err := parseConditional(z, tt, string(tag), attrs, bodyBuff); err != nil {
+				return err
+			} else if handled {
+				for depName, depParams := range deps {
+					c.dependencies[depName] = depParams
+				}
+				stylesheets = append(stylesheets, condStylesheets...)
+				continue
+			}
 			if styleAttrs, isStylesheet := getStylesheet(tag, attrs); isStylesheet {
 				stylesheets = append(stylesheets, styleAttrs)
 				continue
@@ -199,6 +213,9 @@ forloop:
 		if st := strings.Trim(s, " \n"); len(st) > 0 || len(stylesheets) > 0 {
 			frg := NewStringFragment(st)
 			frg.AddStylesheets(stylesheets)
+			if err := frg.Compile(); err != nil {
+				return err
+			}
 			c.body[""] = frg
 		}
 	}
@@ -207,11 +224,27 @@ forloop:
 }
 
 func parseFragment(z *html.Tokenizer) (f Fragment, dependencies map[string]Params, err error) {
-	var stylesheets [][]html.Attribute
+	buff := bytes.NewBuffer(nil)
+	stylesheets, dependencies, err := parseFragmentContent(z, buff, UicFragment, UicTail)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	frg := NewStringFragment(buff.String())
+	frg.AddStylesheets(stylesheets)
+	if err := frg.Compile(); err != nil {
+		return nil, nil, err
+	}
+	return frg, dependencies, nil
+}
+
+// parseFragmentContent writes the content of the current element to buff,
+// until one of the supplied end tags is reached. Nested elements with the same tag name are skipped.
+func parseFragmentContent(z *html.Tokenizer, buff *bytes.Buffer, endTags ...string) (stylesheets [][]html.Attribute, dependencies map[string]Params, err error) {
 	attrs := make([]html.Attribute, 0, 10)
 	dependencies = make(map[string]Params)
+	depth := 0
 
-	buff := bytes.NewBuffer(nil)
 forloop:
 	for {
 		tt := z.Next()
@@ -227,14 +260,13 @@ forloop:
 			break forloop
 		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
 			if string(tag) == UicInclude {
-				if replaceTextStart, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, attrs); err != nil {
+				if deps, includeStylesheets, err := parseInclude(z, tt, attrs, buff); err != nil {
 					return nil, nil, err
 				} else {
-					dependencies[dependencyName] = dependencyParams
-					fmt.Fprintf(buff, replaceTextStart)
-					// Enhancement: WriteOut sub tree, to allow alternative content
-					//              for optional includes.
-					fmt.Fprintf(buff, replaceTextEnd)
+					for depName, depParams := range deps {
+						dependencies[depName] = depParams
+					}
+					stylesheets = append(stylesheets, includeStylesheets...)
 					continue
 				}
 			}
@@ -243,22 +275,175 @@ forloop:
 				continue
 			}
 
+			if handled, deps, condStylesheets, err := parseConditional(z, tt, string(tag), attrs, buff); err != nil {
+				return nil, nil, err
+			} else if handled {
+				for depName, depParams := range deps {
+					dependencies[depName] = depParams
+				}
+				stylesheets = append(stylesheets, condStylesheets...)
+				continue
+			}
+
 			if styleAttrs, isStylesheet := getStylesheet(tag, attrs); isStylesheet {
 				stylesheets = append(stylesheets, styleAttrs)
 				continue
 			}
 
+			if tt == html.StartTagToken && contains(endTags, string(tag)) {
+				depth++
+			}
+
 		case tt == html.EndTagToken:
-			if string(tag) == UicFragment || string(tag) == UicTail {
-				break forloop
+			if contains(endTags, string(tag)) {
+				if depth == 0 {
+					break forloop
+				}
+				depth--
 			}
 		}
 		buff.Write(raw)
 	}
 
-	frg := NewStringFragment(buff.String())
-	frg.AddStylesheets(stylesheets)
-	return frg, dependencies, nil
+	return stylesheets, dependencies, nil
+}
+
+// parseInclude writes the template markers for an uic-include element to buff.
+// An include with the uic-if or uic-else attribute is wrapped in a conditional template block.
+// The child elements of an optional include are written as alternative content
+// between the start and end marker. Child elements of a required include are dropped,
+// because they would never be rendered. An include without end tag, which is not self closing, is an error.
+func parseInclude(z *html.Tokenizer, tt html.TokenType, attrs []html.Attribute, buff *bytes.Buffer) (dependencies map[string]Params, stylesheets [][]html.Attribute, err error) {
+	replaceTextStart, replaceTextEnd, dependencyName, dependencyParams, err := getInclude(z, attrs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conditional, err := startConditionalInclude(z, attrs, buff)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dependencies = map[string]Params{dependencyName: dependencyParams}
+	buff.WriteString(replaceTextStart)
+
+	if tt == html.StartTagToken {
+		altBuff := buff
+		if replaceTextEnd == "" {
+			altBuff = bytes.NewBuffer(nil)
+		}
+		startTag := string(z.Raw())
+		altStylesheets, altDependencies, err := parseFragmentContent(z, altBuff, UicInclude)
+		if err != nil {
+			return nil, nil, err
+		}
+		if z.Err() == io.EOF {
+			return nil, nil, fmt.Errorf("include without end tag %s", startTag)
+		}
+		if replaceTextEnd != "" {
+			stylesheets = altStylesheets
+			for depName, depParams := range altDependencies {
+				if _, exist := dependencies[depName]; !exist {
+					dependencies[depName] = depParams
+				}
+			}
+		}
+	}
+
+	buff.WriteString(replaceTextEnd)
+	if conditional {
+		buff.WriteString(PlaceholderStart + EndConditionalBlock + PlaceholderEnd)
+	}
+	return dependencies, stylesheets, nil
+}
+
+// startConditionalInclude writes the start of a conditional block for an uic-include
+// marked with the uic-if or uic-else attribute and returns true, if the include is conditional.
+func startConditionalInclude(z *html.Tokenizer, attrs []html.Attribute, buff *bytes.Buffer) (conditional bool, err error) {
+	ifAttr, hasIf := getAttr(attrs, UicIf)
+	_, hasElse := getAttr(attrs, UicElse)
+	switch {
+	case hasElse:
+		if err := reopenConditionalBlock(buff); err != nil {
+			return false, fmt.Errorf("%v in %s", err.Error(), z.Raw())
+		}
+	case hasIf:
+		if strings.TrimSpace(ifAttr.Val) == "" {
+			return false, fmt.Errorf("conditional without condition %s", z.Raw())
+		}
+		fmt.Fprintf(buff, "%s%s %s%s", PlaceholderStart, StartConditionalBlock, ifAttr.Val, PlaceholderEnd)
+	}
+	return hasIf || hasElse, nil
+}
+
+// parseConditional writes an element marked with the uic-if or uic-else attribute,
+// or the content of an uic-if or uic-else element, as conditional template block to buff.
+// An uic-else has to follow directly after an uic-if. It is merged into the preceding conditional block.
+// If the element is not a conditional, handled=false is returned and nothing is consumed from the tokenizer.
+func parseConditional(z *html.Tokenizer, tt html.TokenType, tag string, attrs []html.Attribute, buff *bytes.Buffer) (handled bool, dependencies map[string]Params, stylesheets [][]html.Attribute, err error) {
+	isElement := tag == UicIf || tag == UicElse
+	ifAttr, hasIf := getAttr(attrs, UicIf)
+	_, hasElse := getAttr(attrs, UicElse)
+	if !isElement && !hasIf && !hasElse {
+		return false, nil, nil, nil
+	}
+
+	if tag == UicElse || (!isElement && hasElse) {
+		if err := reopenConditionalBlock(buff); err != nil {
+			return true, nil, nil, fmt.Errorf("%v in %s", err.Error(), z.Raw())
+		}
+	} else {
+		condition := ifAttr.Val
+		if isElement {
+			condAttr, _ := getAttr(attrs, UicIfCondition)
+			condition = condAttr.Val
+		}
+		if strings.TrimSpace(condition) == "" {
+			return true, nil, nil, fmt.Errorf("conditional without condition %s", z.Raw())
+		}
+		fmt.Fprintf(buff, "%s%s %s%s", PlaceholderStart, StartConditionalBlock, condition, PlaceholderEnd)
+	}
+
+	if !isElement {
+		buff.WriteString("<" + tag)
+		if elementAttrs := removeAttrs(attrs, UicIf, UicElse); len(elementAttrs) > 0 {
+			buff.WriteString(" " + joinAttrs(elementAttrs))
+		}
+		if tt == html.SelfClosingTagToken {
+			buff.WriteString("/>")
+		} else {
+			buff.WriteString(">")
+		}
+	}
+
+	if !isSelfClosingTag(tag, tt) {
+		stylesheets, dependencies, err = parseFragmentContent(z, buff, tag)
+		if err != nil {
+			return true, nil, nil, err
+		}
+		if !isElement {
+			buff.WriteString("</" + tag + ">")
+		}
+	}
+
+	buff.WriteString(PlaceholderStart + EndConditionalBlock + PlaceholderEnd)
+	return true, dependencies, stylesheets, nil
+}
+
+// reopenConditionalBlock removes the end marker of the conditional block at the end of buff
+// and starts the else block instead. Whitespace after the end marker is preserved.
+func reopenConditionalBlock(buff *bytes.Buffer) error {
+	endMarker := []byte(PlaceholderStart + EndConditionalBlock + PlaceholderEnd)
+	content := buff.Bytes()
+	trimmed := bytes.TrimRight(content, " \t\r\n")
+	if !bytes.HasSuffix(trimmed, endMarker) {
+		return fmt.Errorf("%v without preceding %v", UicElse, UicIf)
+	}
+	whitespace := byteCopy(content[len(trimmed):])
+	buff.Truncate(len(trimmed) - len(endMarker))
+	buff.WriteString(PlaceholderStart + ElseConditionalBlock + PlaceholderEnd)
+	buff.Write(whitespace)
+	return nil
 }
 
 func getInclude(z *html.Tokenizer, attrs []html.Attribute) (startMarker, endMarker, dependencyName string, dependencyParams Params, error error) {
@@ -543,6 +728,17 @@ func getFragmentName(attrs []html.Attribute) string {
 	return ""
 }
 
+// removeAttrs returns a copy of attrs without the attributes with the supplied names
+func removeAttrs(attrs []html.Attribute, names ...string) []html.Attribute {
+	result := make([]html.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if !contains(names, a.Key) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
 func attrHasValue(attrs []html.Attribute, name string, value string) (found bool) {
 	a, found := getAttr(attrs, name)
 	return found && a.Val == value
@@ -459,6 +459,158 @@ func Test_HtmlContentParser_parseBody(t *testing.T) {
 	a.Equal(c.Dependencies()["example.com/foo"], Params{"bli": "bla"})
 }
 
+func Test_HtmlContentParser_parseBody_IncludeAlternativeContent(t *testing.T) {
+	a := assert.New(t)
+
+	parser := &HtmlContentParser{}
+	z := html.NewTokenizer(bytes.NewBufferString(`<body>
+    <uic-include src="example.com/optional#content">
+      <div class="fallback">
+        <link rel="stylesheet" href="/fallback.css">
+        <p>alternative</p>
+        <span uic-remove>removed</span>
+        <uic-include src="example.com/nested">
+          <b>nested alternative</b>
+        </uic-include>
+      </div>
+    </uic-include>
+    <uic-include src="example.com/required" required="true">
+      <p>never rendered</p>
+    </uic-include>
+    <uic-fragment name="content">
+      <uic-include src="example.com/other"><i>other alternative</i></uic-include>
+    </uic-fragment>
+  </body>`))
+
+	z.Next() // At <body ..
+	c := NewMemoryContent()
+	err := parser.parseBody(z, c)
+	a.NoError(err)
+
+	eqFragment(t, `§[#> example.com/optional#content]§
+      <div class="fallback">
+        <p>alternative</p>
+        §[#> example.com/nested]§<b>nested alternative</b>§[/example.com/nested]§
+      </div>
+    §[/example.com/optional#content]§
+    §[> example.com/required]§`, c.Body()[""])
+	eqFragment(t, `§[#> example.com/other]§<i>other alternative</i>§[/example.com/other]§`, c.Body()["content"])
+
+	a.Equal(1, len(c.Body()[""].Stylesheets()))
+	a.Equal(`rel="stylesheet" href="/fallback.css"`, joinAttrs(c.Body()[""].Stylesheets()[0]))
+
+	a.Equal(4, len(c.Dependencies()))
+	a.Contains(c.Dependencies(), "example.com/nested")
+}
+
+func Test_HtmlContentParser_parseBody_Conditionals(t *testing.T) {
+	a := assert.New(t)
+
+	parser := &HtmlContentParser{}
+	z := html.NewTokenizer(bytes.NewBufferString(`<body>
+    <div class="login" uic-if="!user.loggedIn"><div>Login</div></div>
+    <div class="logout" uic-else>Logout</div>
+    <uic-fragment name="content">
+      <uic-if cond="features.teaser == on">
+        <link rel="stylesheet" href="/teaser.css">
+        <uic-include src="example.com/teaser"/>
+      </uic-if>
+      <uic-else>no teaser</uic-else>
+      <br uic-if="exists user.name">
+    </uic-fragment>
+  </body>`))
+
+	z.Next() // At <body ..
+	c := NewMemoryContent()
+	err := parser.parseBody(z, c)
+	a.NoError(err)
+
+	eqFragment(t, `§[? !user.loggedIn]§<div class="login"><div>Login</div></div>
+    §[?else]§<div class="logout">Logout</div>§[/?]§`, c.Body()[""])
+	eqFragment(t, `§[? features.teaser == on]§
+        §[#> example.com/teaser]§§[/example.com/teaser]§
+      §[?else]§no teaser§[/?]§
+      §[? exists user.name]§<br>§[/?]§`, c.Body()["content"])
+
+	a.Equal(1, len(c.Body()["content"].Stylesheets()))
+	a.Contains(c.Dependencies(), "example.com/teaser")
+
+	buf := bytes.NewBufferString("")
+	err = c.Body()[""].Execute(buf, map[string]interface{}{"user": map[string]interface{}{"loggedIn": true}}, nil)
+	a.NoError(err)
+	a.Equal(`<div class="logout">Logout</div>`, strings.TrimSpace(buf.String()))
+}
+
+func Test_HtmlContentParser_parseBody_ConditionalIncludes(t *testing.T) {
+	a := assert.New(t)
+
+	parser := &HtmlContentParser{}
+	z := html.NewTokenizer(bytes.NewBufferString(`<body>
+    <uic-include src="example.com/teaser" uic-if="features.teaser"/>
+    <uic-include src="example.com/fallback" uic-else/>
+    <uic-fragment name="content">
+      <uic-include src="example.com/login" required="true" uic-if="!user.loggedIn"/>
+    </uic-fragment>
+  </body>`))
+
+	z.Next() // At <body ..
+	c := NewMemoryContent()
+	err := parser.parseBody(z, c)
+	a.NoError(err)
+
+	eqFragment(t, `§[? features.teaser]§§[#> example.com/teaser]§§[/example.com/teaser]§
+    §[?else]§§[#> example.com/fallback]§§[/example.com/fallback]§§[/?]§`, c.Body()[""])
+	eqFragment(t, `§[? !user.loggedIn]§§[> example.com/login]§§[/?]§`, c.Body()["content"])
+	a.Contains(c.Dependencies(), "example.com/teaser")
+	a.Contains(c.Dependencies(), "example.com/fallback")
+	a.Contains(c.Dependencies(), "example.com/login")
+}
+
+func Test_HtmlContentParser_parseBody_Conditionals_ErrorCases(t *testing.T) {
+	a := assert.New(t)
+
+	testCases := []string{
+		`<div uic-else>x</div>`,
+		`<div uic-if="">x</div>`,
+		`<uic-if>x</uic-if>`,
+		`<uic-fragment><uic-else>x</uic-else></uic-fragment>`,
+		`<uic-include src="example.com/teaser" uic-else/>`,
+		`<uic-include src="example.com/teaser" uic-if=" "/>`,
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test #%v", i), func(t *testing.T) {
+			parser := &HtmlContentParser{}
+			z := html.NewTokenizer(bytes.NewBufferString(
+				"<body>" + test + "</body>",
+			))
+			z.Next() // At <body ..
+			err := parser.parseBody(z, NewMemoryContent())
+			a.Error(err)
+		})
+	}
+}
+
+func Test_HtmlContentParser_TemplateSyntaxErrors(t *testing.T) {
+	a := assert.New(t)
+
+	testCases := []string{
+		`<html><head><title>§[ foo</title></head></html>`,
+		`<html><body>§[ foo</body></html>`,
+		`<html><body><uic-fragment name="a">§[#> foo]§ alternative</uic-fragment></body></html>`,
+		`<html><body><uic-tail>§[? foo]§</uic-tail></body></html>`,
+	}
+
+	for i, test := range testCases {
+		t.Run(fmt.Sprintf("test #%v", i), func(t *testing.T) {
+			parser := &HtmlContentParser{}
+			err := parser.Parse(NewMemoryContent(), strings.NewReader(test))
+			a.Error(err)
+			a.Contains(err.Error(), "Fragment parsing error")
+		})
+	}
+}
+
 func Test_HtmlContentParser_fetchDependencies(t *testing.T) {
 	a := assert.New(t)
 
@@ -506,6 +658,8 @@ func Test_HtmlContentParser_fetchAndInclude_ErrorCases(t *testing.T) {
 		`<uic-fetch src="example.com/foo" timeout="sdcascdsdc"/>`,
 		`<uic-fragment name="bla"><uic-include/><uic-fragment>`,
 		`<uic-include src="example.com/foo" required="tr42ue"/>`,
+		`<uic-include src="example.com/foo"><p>alternative</p>`,
+		`<uic-fragment name="bla"><uic-include src="example.com/foo"></uic-fragment>`,
 	}
 
 	for i, test := range testCases {
@@ -0,0 +1,333 @@
+package composition
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// htmlState is the state of the html tokenization at a position within a template.
+type htmlState int
+
+const (
+	stateText            htmlState = iota // within html text
+	stateTagName                          // within the name of a tag
+	stateTag                              // within a tag, between the attributes
+	stateAttrName                         // within the name of an attribute
+	stateAfterAttrName                    // behind the name of an attribute, before a possible '='
+	stateBeforeAttrValue                  // behind the '=' of an attribute
+	stateAttrValue                        // within the value of an attribute
+	stateRawText                          // within the content of a script or style element
+	stateComment                          // within an html comment
+	stateUrl                              // within a plain url, like the url of a fetch definition
+)
+
+// urlPart is the part of an url, in which a value is inserted.
+type urlPart int
+
+const (
+	urlStart urlPart = iota // at the start of the url, where the scheme may be defined
+	urlPath                 // within the path of the url
+	urlQuery                // within the query or the fragment of the url
+)
+
+// urlAttributes are the attributes, whose values are urls.
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+	"usemap":     true,
+	"xlink:href": true,
+}
+
+// safeUrlSchemes are the schemes, which may be inserted at the start of an url.
+var safeUrlSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+}
+
+// unsafeUrl replaces urls with an unsafe scheme, like javascript:
+const unsafeUrl = "about:invalid#unsafe-url"
+
+// escapeContext describes the html context of a template variable, which defines how its value is escaped.
+// It is tracked over the static text of a template by advance().
+type escapeContext struct {
+	state    htmlState
+	tag      string // the name of the current tag
+	closing  bool   // true, if the current tag is an end tag
+	attr     string // the name of the current attribute
+	quote    byte   // the quote of the current attribute value, or 0 if it is unquoted
+	urlPart  urlPart
+	jsQuote  byte // the quote of the current javascript string literal, or 0 if there is none
+	jsEscape bool // true, if the last character within the javascript string literal was a backslash
+}
+
+// advance returns the context at the end of the text, which follows at the position of the context.
+func (c escapeContext) advance(text string) escapeContext {
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		switch c.state {
+		case stateText:
+			if strings.HasPrefix(text[i:], "<!--") {
+				c.state = stateComment
+				i += 3
+			} else if b == '<' && i+1 < len(text) && (isAsciiLetter(text[i+1]) || text[i+1] == '/') {
+				c.state, c.tag, c.closing = stateTagName, "", text[i+1] == '/'
+				if c.closing {
+					i++
+				}
+			}
+		case stateTagName:
+			if isHtmlSpace(b) || b == '/' || b == '>' {
+				c.state = stateTag
+				i--
+			} else {
+				c.tag += strings.ToLower(string(b))
+			}
+		case stateTag:
+			if b == '>' {
+				c = c.endOfTag()
+			} else if !isHtmlSpace(b) && b != '/' {
+				c.state, c.attr = stateAttrName, strings.ToLower(string(b))
+			}
+		case stateAttrName:
+			switch {
+			case b == '=':
+				c.state = stateBeforeAttrValue
+			case isHtmlSpace(b):
+				c.state = stateAfterAttrName
+			case b == '>' || b == '/':
+				c.state = stateTag
+				i--
+			default:
+				c.attr += strings.ToLower(string(b))
+			}
+		case stateAfterAttrName:
+			if b == '=' {
+				c.state = stateBeforeAttrValue
+			} else if !isHtmlSpace(b) {
+				c.state = stateTag
+				i--
+			}
+		case stateBeforeAttrValue:
+			switch {
+			case isHtmlSpace(b):
+			case b == '>':
+				c = c.endOfTag()
+			default:
+				c.state, c.quote, c.urlPart, c.jsQuote, c.jsEscape = stateAttrValue, 0, urlStart, 0, false
+				if b == '"' || b == '\'' {
+					c.quote = b
+				} else {
+					i--
+				}
+			}
+		case stateAttrValue:
+			switch {
+			case c.quote != 0 && b == c.quote, c.quote == 0 && isHtmlSpace(b):
+				c.state = stateTag
+			case c.quote == 0 && b == '>':
+				c = c.endOfTag()
+			case urlAttributes[c.attr]:
+				c = c.advanceUrl(b)
+			case isEventAttribute(c.attr):
+				c = c.advanceJs(b)
+			}
+		case stateRawText:
+			if b == '<' && strings.HasPrefix(strings.ToLower(text[i:]), "</"+c.tag) {
+				c.state, c.tag, c.closing = stateTagName, "", true
+				i++
+			} else if c.tag == "script" {
+				c = c.advanceJs(b)
+			}
+		case stateComment:
+			if strings.HasPrefix(text[i:], "-->") {
+				c.state = stateText
+				i += 2
+			}
+		case stateUrl:
+			c = c.advanceUrl(b)
+		}
+	}
+	return c
+}
+
+// endOfTag returns the context behind the end of the current tag.
+func (c escapeContext) endOfTag() escapeContext {
+	if !c.closing && (c.tag == "script" || c.tag == "style") {
+		c.state, c.jsQuote, c.jsEscape = stateRawText, 0, false
+	} else {
+		c.state = stateText
+	}
+	return c
+}
+
+// advanceUrl tracks the part of an url.
+func (c escapeContext) advanceUrl(b byte) escapeContext {
+	if b == '?' || b == '#' {
+		c.urlPart = urlQuery
+	} else if c.urlPart == urlStart {
+		c.urlPart = urlPath
+	}
+	return c
+}
+
+// afterValue returns the context behind an inserted value.
+func (c escapeContext) afterValue() escapeContext {
+	if c.state == stateBeforeAttrValue {
+		c.state, c.quote, c.jsQuote, c.jsEscape = stateAttrValue, 0, 0, false
+	}
+	if (c.state == stateAttrValue || c.state == stateUrl) && c.urlPart == urlStart {
+		c.urlPart = urlPath
+	}
+	return c
+}
+
+// advanceJs tracks the string literals of javascript code.
+func (c escapeContext) advanceJs(b byte) escapeContext {
+	switch {
+	case c.jsEscape:
+		c.jsEscape = false
+	case c.jsQuote != 0 && b == '\\':
+		c.jsEscape = true
+	case c.jsQuote != 0 && b == c.jsQuote:
+		c.jsQuote = 0
+	case c.jsQuote == 0 && (b == '"' || b == '\'' || b == '`'):
+		c.jsQuote = b
+	}
+	return c
+}
+
+// escape escapes the value for the context:
+// Within html text and attribute values, the value is html escaped.
+// Within urls of attributes like href and src, the value is url encoded, depending on the part of the url.
+// At the start of these urls, only safe schemes like http are allowed.
+// Within script elements and event handler attributes, the value is written as javascript value.
+// Within style elements and attributes, all characters, which may change the meaning of the css, are escaped.
+// Within plain urls, like the urls of fetch definitions, the value is url encoded, depending on the part of the url,
+// but a value at the start of the url may contain any scheme and host.
+func (c escapeContext) escape(value interface{}) string {
+	if c.state == stateBeforeAttrValue {
+		// the value is the start of an unquoted attribute value
+		c.state, c.quote, c.urlPart, c.jsQuote = stateAttrValue, 0, urlStart, 0
+	}
+	switch c.state {
+	case stateUrl:
+		if c.urlPart == urlStart {
+			return normalizeUrl(fmt.Sprintf("%v", value))
+		}
+		return escapeUrl(fmt.Sprintf("%v", value), c.urlPart)
+	case stateRawText:
+		if c.tag == "script" {
+			return escapeJs(value, c.jsQuote)
+		}
+		return escapeCss(fmt.Sprintf("%v", value))
+	case stateAttrValue:
+		var escaped string
+		switch {
+		case urlAttributes[c.attr]:
+			escaped = escapeUrl(fmt.Sprintf("%v", value), c.urlPart)
+		case isEventAttribute(c.attr):
+			escaped = escapeJs(value, c.jsQuote)
+		case c.attr == "style":
+			escaped = escapeCss(fmt.Sprintf("%v", value))
+		default:
+			escaped = fmt.Sprintf("%v", value)
+		}
+		escaped = html.EscapeString(escaped)
+		if c.quote == 0 {
+			escaped = unquotedAttrReplacer.Replace(escaped)
+		}
+		return escaped
+	}
+	return html.EscapeString(fmt.Sprintf("%v", value))
+}
+
+// unquotedAttrReplacer escapes the characters, which would end an unquoted attribute value.
+var unquotedAttrReplacer = strings.NewReplacer(" ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;", "\f", "&#12;", "=", "&#61;", "`", "&#96;")
+
+// jsStringReplacer escapes the quotes, which are not escaped by json, and the start of template literal placeholders.
+var jsStringReplacer = strings.NewReplacer("'", `\u0027`, "`", `\u0060`, "$", `\u0024`)
+
+// escapeJs writes the value as javascript value. Within a string literal, the value is escaped as part of the string.
+// Outside of string literals, the value is written as json, so strings are quoted, while numbers and booleans are not.
+// The characters <, > and & are escaped by json, so the value can not end the script element.
+func escapeJs(value interface{}, quote byte) string {
+	if quote == 0 {
+		if encoded, err := json.Marshal(value); err == nil {
+			return string(encoded)
+		}
+	}
+	encoded, _ := json.Marshal(fmt.Sprintf("%v", value))
+	if quote == 0 {
+		return string(encoded)
+	}
+	return jsStringReplacer.Replace(string(encoded[1 : len(encoded)-1]))
+}
+
+// escapeCss escapes all characters except letters, digits and a few harmless characters as css hex escapes.
+func escapeCss(value string) string {
+	escaped := strings.Builder{}
+	for _, r := range value {
+		if r < 128 && (isAsciiLetter(byte(r)) || (r >= '0' && r <= '9') || strings.ContainsRune(" #.,%-_", r)) {
+			escaped.WriteRune(r)
+		} else {
+			fmt.Fprintf(&escaped, `\%x `, r)
+		}
+	}
+	return escaped.String()
+}
+
+// escapeUrl encodes the value for the part of the url, in which it is inserted.
+// At the start of the url, the value may be a full url, which is only normalized, if it has a safe scheme.
+func escapeUrl(value string, part urlPart) string {
+	switch part {
+	case urlPath:
+		return url.PathEscape(value)
+	case urlQuery:
+		return url.QueryEscape(value)
+	}
+	if i := strings.IndexAny(value, ":/?#"); i > -1 && value[i] == ':' && !safeUrlSchemes[strings.ToLower(value[:i])] {
+		return unsafeUrl
+	}
+	return normalizeUrl(value)
+}
+
+// normalizeUrl percent encodes the characters, which are not allowed within urls.
+func normalizeUrl(value string) string {
+	normalized := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if isAsciiLetter(b) || (b >= '0' && b <= '9') || strings.IndexByte("-._~:/?#[]@!$&'()*+,;=%", b) > -1 {
+			normalized.WriteByte(b)
+		} else {
+			fmt.Fprintf(&normalized, "%%%02X", b)
+		}
+	}
+	return normalized.String()
+}
+
+func isEventAttribute(attr string) bool {
+	return strings.HasPrefix(attr, "on")
+}
+
+func isAsciiLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isHtmlSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
@@ -0,0 +1,77 @@
+package composition
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_HtmlEscaping_Contexts(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"q":       `"><script>alert('x')</script>`,
+		"url":     "javascript:alert(1)",
+		"safeUrl": "https://example.com/a b?c=d",
+		"path":    "a/b c",
+		"color":   "red;background:url(x)",
+		"number":  42,
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		// html text and attributes
+		{"<p>§[ q ]§</p>", `<p>&#34;&gt;&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;</p>`},
+		{`<a title="§[ q ]§">`, `<a title="&#34;&gt;&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;">`},
+		{`<a title=§[ path ]§>`, `<a title=a/b&#32;c>`},
+		{`<!-- §[ q ]§ -->`, `<!-- &#34;&gt;&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt; -->`},
+
+		// urls
+		{`<a href="§[ url ]§">`, `<a href="about:invalid#unsafe-url">`},
+		{`<a href="§[ safeUrl ]§">`, `<a href="https://example.com/a%20b?c=d">`},
+		{`<img src=§[ url ]§>`, `<img src=about:invalid#unsafe-url>`},
+		{`<a href="/search/§[ path ]§">`, `<a href="/search/a%2Fb%20c">`},
+		{`<a href="/search?q=§[ q ]§&amp;p=§[ path ]§">`, `<a href="/search?q=%22%3E%3Cscript%3Ealert%28%27x%27%29%3C%2Fscript%3E&amp;p=a%2Fb+c">`},
+		{`<form action='/§[ path ]§'>`, `<form action='/a%2Fb%20c'>`},
+		{`<a class="x" href="§[ safeUrl ]§" title="§[ path ]§">`, `<a class="x" href="https://example.com/a%20b?c=d" title="a/b c">`},
+
+		// scripts
+		{`<script>var q = §[ q ]§, n = §[ number ]§;</script>`, `<script>var q = "\"\u003e\u003cscript\u003ealert('x')\u003c/script\u003e", n = 42;</script>`},
+		{`<script>var q = 'a §[ q ]§';</script>`, `<script>var q = 'a \"\u003e\u003cscript\u003ealert(\u0027x\u0027)\u003c/script\u003e';</script>`},
+		{`<script>var s = "</p>"; var n = §[ number ]§;</script><p>§[ q ]§</p>`, `<script>var s = "</p>"; var n = 42;</script><p>&#34;&gt;&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;</p>`},
+		{`<button onclick="show(§[ path ]§)">`, `<button onclick="show(&#34;a/b c&#34;)">`},
+
+		// styles
+		{`<style>p { color: §[ color ]§ }</style>`, `<style>p { color: red\3b background\3a url\28 x\29  }</style>`},
+		{`<p style="color: §[ color ]§">`, `<p style="color: red\3b background\3a url\28 x\29 ">`},
+		{`<style>p { color: §[ q ]§ }</style><p>§[ path ]§</p>`, `<style>p { color: \22 \3e \3c script\3e alert\28 \27 x\27 \29 \3c \2f script\3e  }</style><p>a/b c</p>`},
+
+		// blocks keep the context
+		{`<a href="/search?§[? path ]§q=§[ path ]§§[/?]§">`, `<a href="/search?q=a%2Fb+c">`},
+		{`<ul>§[#each list]§<li><a href="§[ this ]§">§[ this ]§</a></li>§[/each]§</ul>`, `<ul><li><a href="about:invalid#unsafe-url">javascript:alert(1)</a></li></ul>`},
+	}
+
+	data["list"] = []interface{}{"javascript:alert(1)"}
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil, EscapeHtml)
+		a.NoError(err)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_HtmlEscaping_OtherEscapingsIgnoreTheContext(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{"q": "a b&c"}
+
+	buf := bytes.NewBufferString("")
+	a.NoError(executeTemplate(buf, `<script>var q = §[ q ]§</script>`, data, nil, EscapeNone))
+	a.Equal(`<script>var q = a b&c</script>`, buf.String())
+
+	buf.Reset()
+	a.NoError(executeTemplate(buf, `<a href="§[ q ]§">`, data, nil, EscapeUrl))
+	a.Equal(`<a href="a+b%26c">`, buf.String())
+}
@@ -1,10 +1,13 @@
 package composition
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tarent/go-log-middleware/v2/logging"
 	"github.com/tarent/lib-servicediscovery/servicediscovery"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -13,6 +16,9 @@ import (
 	"time"
 )
 
+// maxDiscoveryAttempts is the number of discoveries to find another instance for a hedged request
+const maxDiscoveryAttempts = 3
+
 var redirectAttemptedError = errors.New("do not follow redirects")
 var noRedirectFunc = func(req *http.Request, via []*http.Request) error {
 	return redirectAttemptedError
@@ -30,29 +36,206 @@ func NewHttpContentLoader() *HttpContentLoader {
 	}
 }
 
-// TODO: Should we filter the headers, which we forward here, or is it correct to copy all of them?
 func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
-	client := &http.Client{Timeout: fd.Timeout}
+	return loader.LoadWithContext(context.Background(), fd)
+}
+
+// LoadWithContext loads the content and cancels the request, if the context is done.
+// If the FetchDefinition has a RetryPolicy, failed attempts are retried within the Timeout of the FetchDefinition.
+func (loader *HttpContentLoader) LoadWithContext(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	if fd.Retry != nil && fd.Retry.appliesTo(fd.Method) {
+		return loader.loadWithRetry(ctx, fd)
+	}
+	return loader.attempt(ctx, fd, fd.Timeout, fd.Body)
+}
+
+// loadWithRetry loads the content with the attempts allowed by the RetryPolicy of the FetchDefinition.
+// The result of the last attempt is returned.
+func (loader *HttpContentLoader) loadWithRetry(ctx context.Context, fd *FetchDefinition) (Content, error) {
+	start := time.Now()
+	remaining := func() time.Duration {
+		return fd.Timeout - time.Since(start)
+	}
+
+	// buffer the body, to be able to send it in each attempt
+	var body []byte
+	if fd.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(fd.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		var attemptBody io.Reader
+		if fd.Body != nil {
+			attemptBody = bytes.NewReader(body)
+		}
+		timeout := fd.Timeout
+		if timeout > 0 {
+			timeout = remaining()
+		}
+
+		c, err := loader.attempt(ctx, fd, timeout, attemptBody)
+		if err == nil || attempt >= fd.Retry.MaxAttempts || !fd.Retry.isRetryable(ctx, c.httpStatusCode, err) {
+			return c, err
+		}
+
+		backoff := fd.Retry.backoff(attempt)
+		if fd.Timeout > 0 && remaining() <= backoff {
+			return c, err
+		}
+		logging.Logger.WithError(err).
+			WithField("full_url", fd.URL).
+			WithField("attempt", attempt).
+			Warnf("retrying %v in %v", fd.URL, backoff)
+
+		select {
+		case <-ctx.Done():
+			return c, err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// attempt makes one attempt to load the content, which is hedged, if configured by the FetchDefinition.
+func (loader *HttpContentLoader) attempt(ctx context.Context, fd *FetchDefinition, timeout time.Duration, body io.Reader) (*MemoryContent, error) {
+	fetchUrl, err := loader.discover(fd, "")
+	if err != nil {
+		return newFailedContent(fd), err
+	}
+	if fd.HedgeDelay > 0 && (fd.Method == "GET" || fd.Method == "HEAD") {
+		return loader.loadHedged(ctx, fd, fetchUrl, timeout)
+	}
+	return loader.load(ctx, fd, fetchUrl, timeout, body)
+}
+
+// loadHedged sends a second request, if the first one has not answered within the HedgeDelay of the FetchDefinition.
+// The first successful response is returned and the other request is cancelled.
+// If service discovery is active, the second request is sent to a different instance, if possible.
+func (loader *HttpContentLoader) loadHedged(ctx context.Context, fd *FetchDefinition, fetchUrl string, timeout time.Duration) (*MemoryContent, error) {
+	type hedgedResult struct {
+		c       *MemoryContent
+		err     error
+		request int
+	}
+	results := make(chan hedgedResult, 2)
+	cancels := []context.CancelFunc{}
+	start := time.Now()
+	send := func(fetchUrl string) {
+		requestCtx, cancel := context.WithCancel(ctx)
+		request := len(cancels)
+		cancels = append(cancels, cancel)
+		requestTimeout := timeout
+		if timeout > 0 {
+			// the hedged request has to finish within the timeout of the first one
+			requestTimeout = timeout - time.Since(start)
+		}
+		go func() {
+			c, err := loader.load(requestCtx, fd, fetchUrl, requestTimeout, nil)
+			results <- hedgedResult{c, err, request}
+		}()
+	}
 
+	send(fetchUrl)
+	running := 1
+	hedge := time.NewTimer(fd.HedgeDelay)
+	defer hedge.Stop()
+
+	for {
+		select {
+		case <-hedge.C:
+			hedgeUrl, err := loader.discover(fd, fetchUrl)
+			if err != nil {
+				logging.Logger.WithError(err).Warnf("no hedged request for %v", fd.URL)
+				continue
+			}
+			logging.Logger.WithField("full_url", fd.URL).Debugf("sending hedged request for %v", fd.URL)
+			send(hedgeUrl)
+			running++
+
+		case result := <-results:
+			running--
+			if result.err != nil && running > 0 {
+				// wait for the other request
+				cancels[result.request]()
+				continue
+			}
+
+			for request, cancel := range cancels {
+				if request != result.request {
+					cancel()
+				}
+			}
+			if running > 0 {
+				go func() {
+					// drain the body of the cancelled request, to make reuse of tcp connections
+					loser := <-results
+					if loser.c.reader != nil {
+						ioutil.ReadAll(loser.c.reader)
+						loser.c.reader.Close()
+					}
+				}()
+			}
+
+			if result.c.reader != nil {
+				// the request must not be cancelled, before the stream is read
+				result.c.reader = &cancelOnClose{ReadCloser: result.c.reader, cancel: cancels[result.request]}
+			} else {
+				cancels[result.request]()
+			}
+			return result.c, result.err
+		}
+	}
+}
+
+// cancelOnClose cancels the context of a request, when the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnClose) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// discover returns the url for the request, which is resolved by the service discovery, if active.
+// If possible, an url different to exclude is returned.
+func (loader *HttpContentLoader) discover(fd *FetchDefinition, exclude string) (fetchUrl string, err error) {
+	if !fd.ServiceDiscoveryActive {
+		return fd.URL, nil
+	}
+	for i := 0; i < maxDiscoveryAttempts; i++ {
+		fetchUrl, err = loader.discoverServiceInUrl(fd.URL, fd.ServiceDiscovery)
+		if err != nil || fetchUrl != exclude {
+			break
+		}
+	}
+	return fetchUrl, err
+}
+
+// newFailedContent creates the content for a failed request
+func newFailedContent(fd *FetchDefinition) *MemoryContent {
 	c := NewMemoryContent()
 	c.name = fd.Name
 	c.httpStatusCode = 502
+	return c
+}
+
+// load sends one request to the url.
+// TODO: Should we filter the headers, which we forward here, or is it correct to copy all of them?
+func (loader *HttpContentLoader) load(ctx context.Context, fd *FetchDefinition, fetchUrl string, timeout time.Duration, body io.Reader) (*MemoryContent, error) {
+	client := &http.Client{Timeout: timeout}
+
+	c := newFailedContent(fd)
 
 	// redirects can only be stopped by returning an error in the CheckRedirect function
 	if !fd.FollowRedirects {
 		client.CheckRedirect = noRedirectFunc
 	}
 
-	fetchUrl := fd.URL
-	if fd.ServiceDiscoveryActive {
-		discoveredUrl, err := loader.discoverServiceInUrl(fetchUrl, fd.ServiceDiscovery)
-		if err != nil {
-			return c, err
-		}
-		fetchUrl = discoveredUrl
-	}
-
-	request, err := http.NewRequest(fd.Method, fetchUrl, fd.Body)
+	request, err := http.NewRequestWithContext(ctx, fd.Method, fetchUrl, body)
 	if err != nil {
 		return c, err
 	}
@@ -88,9 +271,19 @@ func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
 	}
 
 	if c.httpStatusCode < 200 || c.httpStatusCode > 399 {
+		// read and close the body, to make reuse of tcp connections
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		return c, fmt.Errorf("(http %v) on loading url %q", c.httpStatusCode, fd.URL)
 	}
 
+	if c.httpStatusCode == http.StatusNotModified {
+		// there is no body to parse, the caller has to use its own copy of the content
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return c, nil
+	}
+
 	// take the first parser for the content type
 	// direct access to the map does not work, because the
 	// content type may have encoding information at the end
@@ -106,6 +299,7 @@ func (loader *HttpContentLoader) Load(fd *FetchDefinition) (Content, error) {
 				}()
 				parsingStart := time.Now()
 				err := parser.Parse(c, resp.Body)
+				parseDuration.Observe(time.Since(parsingStart).Seconds(), fd.Name)
 				logging.Logger.
 					WithField("full_url", fd.URL).
 					WithField("duration", time.Since(parsingStart)).
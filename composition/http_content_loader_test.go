@@ -1,16 +1,20 @@
 package composition
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/tarent/lib-servicediscovery/servicediscovery"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -173,6 +177,27 @@ func Test_HttpContentLoader_Pass_404(t *testing.T) {
 	a.Equal(404, c.HttpStatusCode())
 }
 
+func Test_HttpContentLoader_NotModified(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(304)
+	}))
+	defer server.Close()
+
+	// the parser is not called for the empty body
+	loader := NewHttpContentLoader()
+	loader.parser["text/html"] = NewMockContentParser(ctrl)
+
+	c, err := loader.Load(NewFetchDefinition(server.URL))
+	a.NoError(err)
+	a.Equal(304, c.HttpStatusCode())
+	a.Nil(c.Reader())
+}
+
 func Test_HttpContentLoader_LoadError500(t *testing.T) {
 	a := assert.New(t)
 
@@ -197,6 +222,176 @@ func Test_HttpContentLoader_LoadErrorNetwork(t *testing.T) {
 	a.Contains(err.Error(), "unsupported protocol scheme")
 }
 
+func Test_HttpContentLoader_LoadWithContext_Cancelled(t *testing.T) {
+	a := assert.New(t)
+
+	server := testServer("the body", time.Second)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*10, cancel)
+
+	start := time.Now()
+	loader := &HttpContentLoader{}
+	_, err := loader.LoadWithContext(ctx, NewFetchDefinition(server.URL))
+	a.Error(err)
+	a.True(errors.Is(err, context.Canceled))
+	a.True(time.Since(start) < time.Millisecond*500)
+}
+
+func Test_HttpContentLoader_Retry(t *testing.T) {
+	a := assert.New(t)
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		a.Equal("post content", string(body))
+		if attempts < 3 {
+			http.Error(w, "Bad Gateway", 502)
+			return
+		}
+		w.Write([]byte("the body"))
+	}))
+	defer server.Close()
+
+	policy := NewRetryPolicy(3)
+	policy.Backoff = time.Millisecond
+	fd := NewFetchDefinition(server.URL).WithRetry(policy)
+	fd.Method = "POST"
+	fd.Body = strings.NewReader("post content")
+
+	// POST is not retried by default
+	loader := &HttpContentLoader{}
+	_, err := loader.Load(fd)
+	a.Error(err)
+	a.Equal(1, attempts)
+
+	attempts = 0
+	policy.RetryNonIdempotent = true
+	fd.Body = strings.NewReader("post content")
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	a.Equal(3, attempts)
+	a.Equal(200, c.HttpStatusCode())
+}
+
+func Test_HttpContentLoader_Retry_WithinTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "Service Unavailable", 503)
+	}))
+	defer server.Close()
+
+	policy := NewRetryPolicy(10)
+	policy.Backoff = 40 * time.Millisecond
+	policy.Jitter = 0
+	fd := NewFetchDefinition(server.URL).WithRetry(policy)
+	fd.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	loader := &HttpContentLoader{}
+	c, err := loader.Load(fd)
+	a.Error(err)
+	a.Equal(503, c.HttpStatusCode())
+	a.Equal(2, attempts)
+	a.True(time.Since(start) < fd.Timeout)
+}
+
+func Test_HttpContentLoader_Hedging(t *testing.T) {
+	a := assert.New(t)
+
+	var requests int32
+	firstCancelled := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+				firstCancelled <- true
+			case <-time.After(time.Second):
+				firstCancelled <- false
+			}
+			return
+		}
+		w.Write([]byte("the body"))
+	}))
+	defer server.Close()
+
+	fd := NewFetchDefinition(server.URL).WithHedging(10 * time.Millisecond)
+
+	start := time.Now()
+	loader := &HttpContentLoader{}
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	a.Equal(200, c.HttpStatusCode())
+	body, _ := ioutil.ReadAll(c.Reader())
+	c.Reader().Close()
+	a.Equal("the body", string(body))
+	a.True(time.Since(start) < 500*time.Millisecond)
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+	a.True(<-firstCancelled)
+}
+
+func Test_HttpContentLoader_Hedging_OnlyForGetAndHead(t *testing.T) {
+	a := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	fd := NewFetchDefinition(server.URL).WithHedging(time.Millisecond)
+	fd.Method = "POST"
+
+	loader := &HttpContentLoader{}
+	_, err := loader.Load(fd)
+	a.NoError(err)
+	a.Equal(int32(1), atomic.LoadInt32(&requests))
+}
+
+func Test_HttpContentLoader_Hedging_WithServiceDiscovery(t *testing.T) {
+	a := assert.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	slow := testServer("slow", time.Second)
+	defer slow.Close()
+	fast := testServer("fast", 0)
+	defer fast.Close()
+
+	hostAndPort := func(server *httptest.Server) (string, string, error) {
+		u, _ := url.Parse(server.URL)
+		host, port, _ := net.SplitHostPort(u.Host)
+		return host, port, nil
+	}
+	slowHost, slowPort, _ := hostAndPort(slow)
+	fastHost, fastPort, _ := hostAndPort(fast)
+
+	// the hedged request has to go to another instance
+	mockServiceDiscovery := servicediscovery.NewMockServiceDiscovery(ctrl)
+	gomock.InOrder(
+		mockServiceDiscovery.EXPECT().DiscoverService("serviceName").Return(slowHost, slowPort, nil),
+		mockServiceDiscovery.EXPECT().DiscoverService("serviceName").Return(slowHost, slowPort, nil),
+		mockServiceDiscovery.EXPECT().DiscoverService("serviceName").Return(fastHost, fastPort, nil),
+	)
+
+	fd := NewFetchDefinition("http://serviceName/").WithHedging(10 * time.Millisecond)
+	fd.ServiceDiscoveryActive = true
+	fd.ServiceDiscovery = mockServiceDiscovery
+
+	loader := &HttpContentLoader{}
+	c, err := loader.Load(fd)
+	a.NoError(err)
+	body, _ := ioutil.ReadAll(c.Reader())
+	c.Reader().Close()
+	a.Equal("fast", string(body))
+}
+
 func Test_HttpContentLoader_FollowRedirects(t *testing.T) {
 	a := assert.New(t)
 
@@ -329,3 +524,27 @@ func testServer(content string, timeout time.Duration) *httptest.Server {
 		w.Write([]byte(content))
 	}))
 }
+
+func Test_HttpContentLoader_Retry_NotAfterCancel(t *testing.T) {
+	a := assert.New(t)
+
+	var attempts int32
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	policy := NewRetryPolicy(5)
+	policy.Backoff = 50 * time.Millisecond
+	fd := NewFetchDefinition(server.URL).WithRetry(policy)
+
+	start := time.Now()
+	loader := &HttpContentLoader{}
+	_, err := loader.LoadWithContext(ctx, fd)
+	a.Error(err)
+	a.Equal(int32(1), atomic.LoadInt32(&attempts))
+	a.True(time.Since(start) < policy.Backoff)
+}
@@ -9,6 +9,7 @@ import (
 	html "golang.org/x/net/html"
 	io "io"
 	http "net/http"
+	time "time"
 )
 
 // Mock of Fragment interface
@@ -285,6 +286,16 @@ func (_mr *_MockContentMergerRecorder) GetHtml() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetHtml")
 }
 
+func (_m *MockContentMerger) WriteHtml(_param0 io.Writer) error {
+	ret := _m.ctrl.Call(_m, "WriteHtml", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockContentMergerRecorder) WriteHtml(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteHtml", arg0)
+}
+
 func (_m *MockContentMerger) SetDeduplicationStrategy(_param0 StylesheetDeduplicationStrategy) {
 	_m.ctrl.Call(_m, "SetDeduplicationStrategy", _param0)
 }
@@ -293,6 +304,14 @@ func (_mr *_MockContentMergerRecorder) SetDeduplicationStrategy(arg0 interface{}
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDeduplicationStrategy", arg0)
 }
 
+func (_m *MockContentMerger) SetEscaping(_param0 Escaping) {
+	_m.ctrl.Call(_m, "SetEscaping", _param0)
+}
+
+func (_mr *_MockContentMergerRecorder) SetEscaping(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetEscaping", arg0)
+}
+
 // Mock of ContentParser interface
 type MockContentParser struct {
 	ctrl     *gomock.Controller
@@ -403,10 +422,15 @@ func (_mr *_MockCacheRecorder) PurgeEntries(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "PurgeEntries", arg0)
 }
 
-func (_m *MockCache) Set(_param0 string, _param1 string, _param2 int, _param3 interface{}) {
-	_m.ctrl.Call(_m, "Set", _param0, _param1, _param2, _param3)
+func (_m *MockCache) Set(_param0 string, _param1 string, _param2 int, _param3 interface{}, _param4 time.Time, _param5 ...string) {
+	_s := []interface{}{_param0, _param1, _param2, _param3, _param4}
+	for _, _x := range _param5 {
+		_s = append(_s, _x)
+	}
+	_m.ctrl.Call(_m, "Set", _s...)
 }
 
-func (_mr *_MockCacheRecorder) Set(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "Set", arg0, arg1, arg2, arg3)
+func (_mr *_MockCacheRecorder) Set(arg0, arg1, arg2, arg3, arg4 interface{}, arg5 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1, arg2, arg3, arg4}, arg5...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Set", _s...)
 }
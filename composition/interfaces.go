@@ -6,9 +6,12 @@ package composition
 //go:generate sh ../scripts/mockgen.sh
 
 import (
+	"context"
 	"io"
 	"net/http"
+	"time"
 
+	"github.com/tarent/lib-compose/v2/cache"
 	"golang.org/x/net/html"
 )
 
@@ -22,12 +25,36 @@ type Fragment interface {
 	Stylesheets() [][]html.Attribute
 }
 
+// EscapingFragment is a Fragment, which allows to choose the escaping of template variables.
+// Execute() of an EscapingFragment has to use EscapeHtml.
+type EscapingFragment interface {
+	Fragment
+
+	ExecuteWithEscaping(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error
+}
+
+// IncludingFragment is a Fragment, which can tell the names of the fragments it includes.
+type IncludingFragment interface {
+	Fragment
+
+	// Includes returns the names of all fragments, which may be included on execution
+	Includes() []string
+}
+
 type ContentLoader interface {
 	// Load synchronously loads a content.
 	// The loader has to ensure to return the call withing the supplied timeout.
 	Load(fd *FetchDefinition) (content Content, err error)
 }
 
+// ContextContentLoader is a ContentLoader, which supports the cancellation of the loading by a context.
+type ContextContentLoader interface {
+	ContentLoader
+
+	// LoadWithContext loads a content like Load(), but stops loading, if the context is done.
+	LoadWithContext(ctx context.Context, fd *FetchDefinition) (content Content, err error)
+}
+
 type ContentParser interface {
 	// Parse parses the input stream into a Content Object
 	Parse(*MemoryContent, io.Reader) error
@@ -44,6 +71,16 @@ type FetchResultSupplier interface {
 	Empty() bool
 }
 
+// ProgressiveFetchResultSupplier is a FetchResultSupplier,
+// which is able to return the results before the optional fetch jobs are done.
+type ProgressiveFetchResultSupplier interface {
+	FetchResultSupplier
+
+	// WaitForResultsUntil returns the finished results, after all required fetch jobs are done and the deadline passed,
+	// or all fetch jobs are done. The pending results are returned separately.
+	WaitForResultsUntil(deadline time.Time) (results []*FetchResult, pending []*FetchResult)
+}
+
 type CacheStrategy interface {
 	Hash(method string, url string, requestHeader http.Header) string
 	IsCacheable(method string, url string, statusCode int, requestHeader http.Header, responseHeader http.Header) bool
@@ -106,6 +143,14 @@ type ContentV2 interface {
 	BodyAttributesArray() []html.Attribute
 }
 
+// StaleContent is a Content, which is served stale out of the cache, because loading it failed.
+type StaleContent interface {
+	Content
+
+	// StaleCause returns the error of the failed loading
+	StaleCause() error
+}
+
 type ContentMerger interface {
 	// Add content to the merger
 	AddContent(c Content, priority int)
@@ -117,6 +162,32 @@ type ContentMerger interface {
 	SetDeduplicationStrategy(stategy StylesheetDeduplicationStrategy)
 }
 
+// StreamingContentMerger is a ContentMerger, which is able to write the html while it is rendered.
+type StreamingContentMerger interface {
+	ContentMerger
+
+	// Write the html to the writer, while it is rendered
+	WriteHtml(w io.Writer) error
+}
+
+// EscapingContentMerger is a ContentMerger, which supports the configuration of the escaping.
+type EscapingContentMerger interface {
+	ContentMerger
+
+	// Set the escaping for template variables within the fragments
+	SetEscaping(escaping Escaping)
+}
+
+// ProgressiveContentMerger is a ContentMerger, which is able to render the page
+// before all contents have arrived.
+type ProgressiveContentMerger interface {
+	ContentMerger
+
+	// Write the html to the writer, while the contents with the supplied names are still pending.
+	// waitForPending blocks until the pending contents have arrived and returns them.
+	WriteHtmlProgressive(w io.Writer, pendingContentNames []string, waitForPending func() []Content) error
+}
+
 type ResponseProcessor interface {
 	// Process html from responsebody before composition is triggered
 	// May create a new Reader inside the ResponseBody
@@ -130,11 +201,34 @@ type ErrorHandler interface {
 
 type Cache interface {
 	Get(hash string) (cacheObject interface{}, found bool)
-	Set(hash string, label string, memorySize int, cacheObject interface{})
+	Set(hash string, label string, memorySize int, cacheObject interface{}, expiry time.Time, tags ...string)
 	Invalidate()
 	PurgeEntries(keys []string)
 }
 
+// StaleCache is a Cache, which is able to return entries after their expiry.
+type StaleCache interface {
+	Cache
+
+	// GetStale returns the entry, even if it is expired.
+	// staleFor is the time since the expiry, or < 0, if the entry is fresh.
+	GetStale(hash string) (cacheObject interface{}, staleFor time.Duration, found bool)
+}
+
+// SelectiveCache is a Cache, which is able to purge selected entries.
+type SelectiveCache interface {
+	Cache
+
+	// PurgeByLabel removes all entries with the label, which is the url of the fetch definition.
+	PurgeByLabel(label string) []cache.PurgedEntry
+
+	// PurgeByLabelPrefix removes all entries with a label, which starts with the prefix.
+	PurgeByLabelPrefix(prefix string) []cache.PurgedEntry
+
+	// PurgeByTag removes all entries, which were set with the tag.
+	PurgeByTag(tag string) []cache.PurgedEntry
+}
+
 type StylesheetDeduplicationStrategy interface {
 	Deduplicate(stylesheetAttrs [][]html.Attribute) [][]html.Attribute
 }
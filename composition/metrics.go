@@ -0,0 +1,99 @@
+package composition
+
+import (
+	"github.com/tarent/lib-compose/v2/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metrics of the composition are registered at metrics.Default.
+var (
+	fetchDuration = metrics.Default.NewHistogram("compose_fetch_duration_seconds",
+		"Duration of fetches by fetch definition name, host and outcome.",
+		metrics.DefaultBuckets, "name", "host", "outcome")
+
+	parseDuration = metrics.Default.NewHistogram("compose_parse_duration_seconds",
+		"Duration of the parsing of contents by fetch definition name.",
+		metrics.DefaultBuckets, "name")
+
+	compositionDuration = metrics.Default.NewHistogram("compose_composition_duration_seconds",
+		"Duration of compositions by response status.",
+		metrics.DefaultBuckets, "status")
+)
+
+// observeFetch records the duration and outcome of a fetch.
+// The fetch definition has to be the one with the unexpanded url (see hostLabel()).
+func observeFetch(fd *FetchDefinition, result *FetchResult, duration time.Duration) {
+	fetchDuration.Observe(duration.Seconds(), fd.Name, hostLabel(fd), fetchOutcome(result))
+}
+
+// hostLabel returns the host of the unexpanded url of the fetch definition as label value.
+// Hosts defined by template variables, like §[request.base_url]§, are labeled as "dynamic",
+// because their values may be taken from request headers and the number of label values has to be bounded.
+func hostLabel(fd *FetchDefinition) string {
+	if i := strings.Index(fd.URL, "§["); i > -1 {
+		static := fd.URL[:i]
+		if j := strings.Index(static, "//"); j > -1 {
+			static = static[j+2:]
+		}
+		// the host is static, if it is followed by the path, query or fragment before the first variable
+		if !strings.ContainsAny(static, "/?#") {
+			return "dynamic"
+		}
+	}
+	return backendOf(fd)
+}
+
+// fetchOutcome returns the outcome of a fetch as label value.
+func fetchOutcome(result *FetchResult) string {
+	switch result.Err.(type) {
+	case nil:
+		if result.Stale != nil {
+			return "stale"
+		}
+		return "success"
+	case *FetchCancelledError:
+		return "cancelled"
+	case *BudgetExceededError:
+		return "budget_exceeded"
+	case *CircuitOpenError:
+		return "circuit_open"
+	}
+	return "error"
+}
+
+// statusRecorder remembers the status code of the response for the metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// statusLabel returns the status code as label value, or "none", if nothing was written, e.g. on cancellation.
+func (sr *statusRecorder) statusLabel() string {
+	if sr.status == 0 {
+		return "none"
+	}
+	return strconv.Itoa(sr.status)
+}
@@ -0,0 +1,64 @@
+package composition
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/v2/metrics"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Metrics_FetchOutcome(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("success", fetchOutcome(&FetchResult{}))
+	a.Equal("stale", fetchOutcome(&FetchResult{Stale: errors.New("failed")}))
+	a.Equal("cancelled", fetchOutcome(&FetchResult{Err: &FetchCancelledError{Cause: context.Canceled}}))
+	a.Equal("budget_exceeded", fetchOutcome(&FetchResult{Err: &BudgetExceededError{}}))
+	a.Equal("circuit_open", fetchOutcome(&FetchResult{Err: &CircuitOpenError{}}))
+	a.Equal("error", fetchOutcome(&FetchResult{Err: errors.New("failed")}))
+}
+
+func Test_Metrics_FetchDuration(t *testing.T) {
+	a := assert.New(t)
+
+	fetcher := NewContentFetcher(nil)
+	fetcher.Loader = contextLoaderFunc(func(ctx context.Context, fd *FetchDefinition) (Content, error) {
+		return NewMemoryContent(), nil
+	})
+	fetcher.AddFetchJob(NewFetchDefinition("http://metrics-test.example.de/foo").WithName("metrics-test"))
+	fetcher.WaitForResults()
+
+	b := &bytes.Buffer{}
+	metrics.Default.WriteMetrics(b)
+	a.Contains(b.String(), `compose_fetch_duration_seconds_count{name="metrics-test",host="metrics-test.example.de",outcome="success"} 1`)
+}
+
+func Test_Metrics_HostLabel(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("example.de", hostLabel(NewFetchDefinition("http://example.de/§[ path ]§")))
+	a.Equal("example.de", hostLabel(NewFetchDefinition("http://example.de?q=§[ q ]§")))
+	a.Equal("", hostLabel(NewFetchDefinition("/foo/§[ path ]§")))
+
+	// hosts out of variables, which may be taken from request headers, are not used as label
+	a.Equal("dynamic", hostLabel(NewFetchDefinition("§[ request.base_url ]§/foo")))
+	a.Equal("dynamic", hostLabel(NewFetchDefinition("http://§[ host ]§/foo")))
+	a.Equal("dynamic", hostLabel(NewFetchDefinition("http://example.§[ tld ]§/foo")))
+}
+
+func Test_Metrics_StatusRecorder(t *testing.T) {
+	a := assert.New(t)
+
+	recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	a.Equal("none", recorder.statusLabel())
+	recorder.Write([]byte("foo"))
+	recorder.WriteHeader(500)
+	a.Equal("200", recorder.statusLabel())
+
+	recorder = &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	recorder.WriteHeader(404)
+	a.Equal("404", recorder.statusLabel())
+}
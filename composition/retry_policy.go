@@ -0,0 +1,110 @@
+package composition
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultRetryBackoff    = 50 * time.Millisecond
+	DefaultRetryMaxBackoff = time.Second
+	DefaultRetryJitter     = 0.2
+)
+
+// DefaultRetryableStatusCodes are the status codes of responses, which are retried by default.
+var DefaultRetryableStatusCodes = []int{502, 503, 504}
+
+// idempotentMethods are the http methods, which may be retried without side effects
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE"}
+
+// RetryPolicy defines, if and how often a failed fetch is retried.
+// All attempts together have to finish within the Timeout of the FetchDefinition.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the first one
+	MaxAttempts int
+
+	// The backoff before the first retry. It is doubled for each further retry.
+	Backoff time.Duration
+
+	// The upper limit of the backoff, or 0 for no limit
+	MaxBackoff time.Duration
+
+	// The random deviation of the backoff as fraction, e.g. 0.2 for +/- 20%
+	Jitter float64
+
+	// The status codes of responses, which are retried
+	RetryableStatusCodes []int
+
+	// Retry, if the request timed out
+	RetryOnTimeout bool
+
+	// Retry on network errors, e.g. if the connection was refused or reset
+	RetryOnConnectionError bool
+
+	// Retry methods, which are not idempotent, e.g. POST.
+	// The request body is buffered in this case, to be able to send it again.
+	RetryNonIdempotent bool
+}
+
+// NewRetryPolicy creates a RetryPolicy with maxAttempts and exponential backoff with jitter,
+// which retries idempotent requests on connection errors and the DefaultRetryableStatusCodes.
+// Timeouts are not retried by default, because the timeout mostly takes the whole time of the fetch.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:            maxAttempts,
+		Backoff:                DefaultRetryBackoff,
+		MaxBackoff:             DefaultRetryMaxBackoff,
+		Jitter:                 DefaultRetryJitter,
+		RetryableStatusCodes:   DefaultRetryableStatusCodes,
+		RetryOnConnectionError: true,
+	}
+}
+
+// appliesTo returns true, if requests with the method may be retried.
+func (policy *RetryPolicy) appliesTo(method string) bool {
+	return policy.MaxAttempts > 1 && (policy.RetryNonIdempotent || contains(idempotentMethods, method))
+}
+
+// isRetryable returns true, if a failed attempt with the status code and error may be retried.
+// Nothing is retried, if the context of the caller is done or the request was cancelled.
+func (policy *RetryPolicy) isRetryable(ctx context.Context, statusCode int, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if urlError, isUrlError := err.(*url.Error); isUrlError {
+		if urlError.Err == redirectAttemptedError {
+			return false
+		}
+		if netError, isNetError := urlError.Err.(net.Error); isNetError && netError.Timeout() {
+			return policy.RetryOnTimeout
+		}
+		return policy.RetryOnConnectionError
+	}
+	for _, code := range policy.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the duration to wait before the next attempt, after the supplied number of attempts.
+func (policy *RetryPolicy) backoff(attempts int) time.Duration {
+	backoff := policy.Backoff
+	// the doubling stops far below the maximum duration, so the jitter can not overflow it
+	for i := 1; i < attempts && (policy.MaxBackoff <= 0 || backoff < policy.MaxBackoff) && backoff < math.MaxInt64/4; i++ {
+		backoff *= 2
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		backoff = time.Duration(float64(backoff) * (1 + policy.Jitter*(2*rand.Float64()-1)))
+	}
+	return backoff
+}
@@ -0,0 +1,84 @@
+package composition
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RetryPolicy_AppliesTo(t *testing.T) {
+	a := assert.New(t)
+
+	policy := NewRetryPolicy(3)
+	a.True(policy.appliesTo("GET"))
+	a.True(policy.appliesTo("PUT"))
+	a.False(policy.appliesTo("POST"))
+	a.False(policy.appliesTo("PATCH"))
+
+	policy.RetryNonIdempotent = true
+	a.True(policy.appliesTo("POST"))
+
+	a.False(NewRetryPolicy(1).appliesTo("GET"))
+}
+
+func Test_RetryPolicy_IsRetryable(t *testing.T) {
+	a := assert.New(t)
+
+	ctx := context.Background()
+	policy := NewRetryPolicy(3)
+	a.True(policy.isRetryable(ctx, 502, errors.New("(http 502)")))
+	a.True(policy.isRetryable(ctx, 503, errors.New("(http 503)")))
+	a.False(policy.isRetryable(ctx, 500, errors.New("(http 500)")))
+	a.False(policy.isRetryable(ctx, 404, errors.New("(http 404)")))
+
+	connectionError := &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection refused")}
+	a.True(policy.isRetryable(ctx, 502, connectionError))
+	policy.RetryOnConnectionError = false
+	a.False(policy.isRetryable(ctx, 502, connectionError))
+
+	timeoutError := &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutErr{}}
+	a.False(policy.isRetryable(ctx, 502, timeoutError))
+	policy.RetryOnTimeout = true
+	a.True(policy.isRetryable(ctx, 502, timeoutError))
+
+	// cancelled requests are not retried
+	cancelledError := &url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}
+	a.False(policy.isRetryable(ctx, 502, cancelledError))
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	a.False(policy.isRetryable(cancelledCtx, 502, connectionError))
+	a.False(policy.isRetryable(cancelledCtx, 502, errors.New("(http 502)")))
+}
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	a := assert.New(t)
+
+	policy := NewRetryPolicy(5)
+	policy.Jitter = 0
+	a.Equal(50*time.Millisecond, policy.backoff(1))
+	a.Equal(100*time.Millisecond, policy.backoff(2))
+	a.Equal(200*time.Millisecond, policy.backoff(3))
+	a.Equal(time.Second, policy.backoff(10))
+
+	// without MaxBackoff, the backoff grows without limit
+	policy.MaxBackoff = 0
+	a.Equal(200*time.Millisecond, policy.backoff(3))
+	a.Equal(25600*time.Millisecond, policy.backoff(10))
+	a.True(policy.backoff(100) > 0)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(1)
+		a.True(backoff >= 25*time.Millisecond && backoff <= 75*time.Millisecond)
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
@@ -2,14 +2,19 @@ package composition
 
 import (
 	"io"
+	"sync/atomic"
 
 	"golang.org/x/net/html"
 )
 
 // StringFragment is a simple template based representation of a fragment.
+// The template is compiled on the first execution, or by an explicit call of Compile().
 type StringFragment struct {
 	content     string
 	stylesheets [][]html.Attribute
+
+	// the compiled template of the content, holding a compiledTemplate
+	compiled atomic.Value
 }
 
 func NewStringFragment(c string) *StringFragment {
@@ -25,6 +30,7 @@ func (f *StringFragment) Content() string {
 
 func (f *StringFragment) SetContent(content string) {
 	f.content = content
+	f.compiled.Store(compiledTemplate(nil))
 }
 
 func (f *StringFragment) Stylesheets() [][]html.Attribute {
@@ -35,8 +41,45 @@ func (f *StringFragment) AddStylesheets(stylesheets [][]html.Attribute) {
 	f.stylesheets = append(f.stylesheets, stylesheets...)
 }
 
+// Compile parses the content of the fragment and keeps the compiled template for later executions.
+// An error is returned, if the content has syntax errors.
+func (f *StringFragment) Compile() error {
+	_, err := f.compiledTemplate()
+	return err
+}
+
+func (f *StringFragment) compiledTemplate() (compiledTemplate, error) {
+	if compiled, _ := f.compiled.Load().(compiledTemplate); compiled != nil {
+		return compiled, nil
+	}
+	compiled, err := compileTemplate(f.content)
+	if err != nil {
+		return nil, err
+	}
+	f.compiled.Store(compiled)
+	return compiled, nil
+}
+
 func (f *StringFragment) Execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
-	return executeTemplate(w, f.Content(), data, executeNestedFragment)
+	return f.ExecuteWithEscaping(w, data, executeNestedFragment, EscapeHtml)
+}
+
+func (f *StringFragment) ExecuteWithEscaping(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	compiled, err := f.compiledTemplate()
+	if err != nil {
+		return err
+	}
+	return compiled.execute(w, data, executeNestedFragment, escaping)
+}
+
+// Includes returns the names of all fragments, which may be included on execution.
+// If the content has syntax errors, nil is returned.
+func (f *StringFragment) Includes() []string {
+	compiled, err := f.compiledTemplate()
+	if err != nil {
+		return nil
+	}
+	return compiled.includes()
 }
 
 // MemorySize return the estimated size in bytes, for this object in memory
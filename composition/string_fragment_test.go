@@ -24,3 +24,58 @@ func Test_StringFragment(t *testing.T) {
 
 	a.Equal("bar", buf.String())
 }
+
+func Test_StringFragment_Loop(t *testing.T) {
+	a := assert.New(t)
+
+	f := NewStringFragment("<ul>§[#each nav.items as item]§<li>§[> item]§:§[ item.name ]§</li>§[/each]§</ul>")
+	buf := bytes.NewBufferString("")
+	data := map[string]interface{}{
+		"nav": map[string]interface{}{
+			"items": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+		},
+	}
+	err := f.Execute(buf, data, func(name string) error {
+		buf.WriteString(name)
+		return nil
+	})
+	a.NoError(err)
+
+	a.Equal("<ul><li>item:a</li><li>item:b</li></ul>", buf.String())
+}
+
+func Test_StringFragment_Escaping(t *testing.T) {
+	a := assert.New(t)
+
+	f := NewStringFragment("§[foo]§ §[& foo]§")
+	data := map[string]interface{}{"foo": "<br>"}
+
+	buf := bytes.NewBufferString("")
+	a.NoError(f.Execute(buf, data, nil))
+	a.Equal("&lt;br&gt; <br>", buf.String())
+
+	buf.Reset()
+	a.NoError(f.ExecuteWithEscaping(buf, data, nil, EscapeNone))
+	a.Equal("<br> <br>", buf.String())
+}
+
+func Test_StringFragment_Compile(t *testing.T) {
+	a := assert.New(t)
+
+	f := NewStringFragment("§[foo]§")
+	a.NoError(f.Compile())
+
+	buf := bytes.NewBufferString("")
+	a.NoError(f.Execute(buf, map[string]interface{}{"foo": "bar"}, nil))
+	a.Equal("bar", buf.String())
+
+	// the compiled template is replaced on content changes
+	f.SetContent("§[foo]§-§[foo]§")
+	buf.Reset()
+	a.NoError(f.Execute(buf, map[string]interface{}{"foo": "bar"}, nil))
+	a.Equal("bar-bar", buf.String())
+
+	f.SetContent("§[foo")
+	a.Error(f.Compile())
+	a.Error(f.Execute(buf, nil, nil))
+}
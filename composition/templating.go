@@ -2,84 +2,505 @@ package composition
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/url"
+	"reflect"
 	"strings"
 )
 
 const (
-	PlaceholderStart  = "§["
-	PlaceholderEnd    = "]§"
-	StartInclude      = ">"
-	StartIncludeBlock = "#>"
-	EndIncludeBlock   = "/"
+	PlaceholderStart       = "§["
+	PlaceholderEnd         = "]§"
+	StartInclude           = ">"
+	StartIncludeBlock      = "#>"
+	EndIncludeBlock        = "/"
+	StartConditionalBlock  = "?"
+	ElseConditionalBlock   = "?else"
+	EndConditionalBlock    = "/?"
+	ConditionExistsKeyword = "exists "
+	StartLoopBlock         = "#each"
+	EndLoopBlock           = "/each"
+	LoopVariableKeyword    = " as "
+	DefaultLoopVariable    = "this"
+	StartRawVariable       = "&"
+
+	// PendingPlaceholderElement is the html element, which marks the place of a pending fragment
+	PendingPlaceholderElement = "uic-pending"
 )
 
+// Escaping defines, how the values of template variables are written to the output.
+type Escaping int
+
+const (
+	// EscapeHtml escapes the values depending on their context within the html:
+	// In html text and attribute values, they are html escaped, in urls of attributes like href and src url encoded,
+	// and in script and style elements and attributes escaped as javascript values and for css.
+	// This is the default for fragments.
+	EscapeHtml Escaping = iota
+
+	// EscapeUrl encodes the values for the use in urls. In the urls of fetch definitions, this depends on the part of the url:
+	// A value at the start of the url may contain the scheme and host and is only normalized,
+	// values within the path are path escaped and values within the query are query escaped.
+	// This is the default for the urls of fetch definitions.
+	EscapeUrl
+
+	// EscapeNone writes the values unescaped.
+	// Only use this for migration of templates, which rely on unescaped output.
+	EscapeNone
+)
+
+// escape escapes the value for the html context, in which it is inserted.
+// The context is only considered by EscapeHtml.
+func (e Escaping) escape(value interface{}, context escapeContext) string {
+	switch e {
+	case EscapeHtml:
+		return context.escape(value)
+	case EscapeUrl:
+		if context.state == stateUrl {
+			return context.escape(value)
+		}
+		return url.QueryEscape(fmt.Sprintf("%v", value))
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+type escapingContextKey struct{}
+
+// ContextWithEscaping returns a context, which carries the escaping of template variables.
+// It is set by the CompositionHandler on the request, if configured by CompositionHandler.WithEscaping(),
+// so that a ContentFetcher created by NewContentFetcherWithContext(r.Context(), ...) uses the same escaping.
+func ContextWithEscaping(ctx context.Context, escaping Escaping) context.Context {
+	return context.WithValue(ctx, escapingContextKey{}, escaping)
+}
+
+// EscapingFromContext returns the escaping of template variables out of the context, if there is one.
+func EscapingFromContext(ctx context.Context) (Escaping, bool) {
+	escaping, found := ctx.Value(escapingContextKey{}).(Escaping)
+	return escaping, found
+}
+
 // Write a template to an output stream.
-// The following replacements will be done:
-// §[ aVariable ]§ inserts a variable from the data map
+// The template is compiled by compileTemplate() on each call.
+// For repeated execution, use a StringFragment which keeps the compiled template.
+func executeTemplate(w io.Writer, template string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	compiled, err := compileTemplate(template)
+	if err != nil {
+		return err
+	}
+	return compiled.execute(w, data, executeNestedFragment, escaping)
+}
+
+// compiledTemplate is the parsed representation of a template as list of nodes.
+type compiledTemplate []templateNode
+
+type templateNode interface {
+	execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error
+}
+
+// compileTemplate parses a template into a list of nodes.
+// The following replacements will be done on execution:
+// §[ aVariable ]§ inserts a variable from the data map, escaped as defined by escaping and the html context of the variable
+// §[& aVariable ]§ inserts a variable from the data map without escaping
 // §[> fragment ]§ executes a nested fragment by executeNestedFragment() and fails on error
 // §[#> fragment ]§ alt text §[/fragment]§ executes a nested fragment by executeNestedFragment().
 //                  On Error, the alternative Text within the block will be executed.
-func executeTemplate(w io.Writer, template string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
+// §[? condition ]§ text §[?else]§ else text §[/?]§ executes the text, if the condition evaluates to true
+//                  and the optional else text otherwise. See evaluateCondition() for the condition syntax.
+// §[#each list as item ]§ text §[/each]§ executes the text for each element of the list,
+//                  where the current element is available as variable item, or as 'this' if no name is given.
+func compileTemplate(template string) (compiledTemplate, error) {
+	compiled, _, err := compileTemplateInContext(template, escapeContext{})
+	return compiled, err
+}
+
+// compileTemplateInContext compiles a template, which starts in the html context,
+// and returns the html context at the end of the template.
+// The context is tracked over the static text, to choose the escaping of the variables.
+// Of blocks with alternatives, the first one is assumed to define the context behind the block.
+func compileTemplateInContext(template string, htmlContext escapeContext) (compiledTemplate, escapeContext, error) {
+	compiled := compiledTemplate{}
 	t := template
 	for len(t) > 0 {
 		start := strings.Index(t, PlaceholderStart)
-		if start > -1 {
-			end := strings.Index(t, PlaceholderEnd)
-			if end < start {
-				return fmt.Errorf("Fragment parsing error, missing ending separator: %v", template)
+		if start == -1 {
+			compiled = append(compiled, textNode(t))
+			htmlContext = htmlContext.advance(t)
+			break
+		}
+
+		end := strings.Index(t, PlaceholderEnd)
+		if end < start {
+			return nil, htmlContext, fmt.Errorf("Fragment parsing error, missing ending separator: %v", template)
+		}
+		if start > 0 {
+			compiled = append(compiled, textNode(t[:start]))
+			htmlContext = htmlContext.advance(t[:start])
+		}
+		placeholder := t[start+len(PlaceholderStart) : end]
+
+		if placeholder == ElseConditionalBlock || placeholder == EndConditionalBlock {
+			return nil, htmlContext, fmt.Errorf("Fragment parsing error, %v without conditional block: %v", PlaceholderStart+placeholder+PlaceholderEnd, template)
+		} else if strings.HasPrefix(placeholder, StartConditionalBlock) {
+			condition := strings.TrimSpace(strings.TrimPrefix(placeholder, StartConditionalBlock))
+			if condition == "" {
+				return nil, htmlContext, fmt.Errorf("Fragment parsing error, conditional block without condition: %v", template)
 			}
-			io.WriteString(w, t[:start])
-			placeholder := t[start+len(PlaceholderStart) : end]
-
-			if strings.HasPrefix(placeholder, StartIncludeBlock) {
-				placeholder = strings.TrimSpace(strings.TrimPrefix(placeholder, StartIncludeBlock))
-				blockEndText := PlaceholderStart + EndIncludeBlock + placeholder + PlaceholderEnd
-				blockEndTextPosition := strings.Index(t, blockEndText)
-				if blockEndTextPosition < end {
-					return fmt.Errorf("Fragment parsing error, missing ending block: %v", blockEndText)
-				}
-				if err := executeNestedFragment(placeholder); err != nil {
-					io.WriteString(w, t[end+len(PlaceholderEnd):blockEndTextPosition])
-				}
-				t = t[blockEndTextPosition+len(blockEndText):]
-			} else {
-				if err := writePlaceholder(w, placeholder, data, executeNestedFragment); err != nil {
-					return err
+			blockStart := end + len(PlaceholderEnd)
+			endMarker := PlaceholderStart + EndConditionalBlock + PlaceholderEnd
+			elseStart, elseEnd, blockEnd, err := findBlock(t[blockStart:], StartConditionalBlock, PlaceholderStart+ElseConditionalBlock+PlaceholderEnd, endMarker)
+			if err != nil {
+				return nil, htmlContext, err
+			}
+			node := &conditionalNode{condition: condition}
+			thenContext := htmlContext
+			if node.then, thenContext, err = compileTemplateInContext(t[blockStart:blockStart+elseStart], htmlContext); err != nil {
+				return nil, htmlContext, err
+			}
+			if elseEnd < blockEnd {
+				if node.otherwise, _, err = compileTemplateInContext(t[blockStart+elseEnd:blockStart+blockEnd], htmlContext); err != nil {
+					return nil, htmlContext, err
 				}
-				t = t[end+len(PlaceholderEnd):]
 			}
+			compiled = append(compiled, node)
+			htmlContext = thenContext
+			t = t[blockStart+blockEnd+len(endMarker):]
+		} else if strings.HasPrefix(placeholder, StartLoopBlock) {
+			blockStart := end + len(PlaceholderEnd)
+			endMarker := PlaceholderStart + EndLoopBlock + PlaceholderEnd
+			_, _, blockEnd, err := findBlock(t[blockStart:], StartLoopBlock, "", endMarker)
+			if err != nil {
+				return nil, htmlContext, err
+			}
+			node := newLoopNode(strings.TrimSpace(strings.TrimPrefix(placeholder, StartLoopBlock)))
+			if node.body, htmlContext, err = compileTemplateInContext(t[blockStart:blockStart+blockEnd], htmlContext); err != nil {
+				return nil, htmlContext, err
+			}
+			compiled = append(compiled, node)
+			t = t[blockStart+blockEnd+len(endMarker):]
+		} else if strings.HasPrefix(placeholder, StartIncludeBlock) {
+			placeholder = strings.TrimSpace(strings.TrimPrefix(placeholder, StartIncludeBlock))
+			blockEndText := PlaceholderStart + EndIncludeBlock + placeholder + PlaceholderEnd
+			blockEndTextPosition := strings.Index(t, blockEndText)
+			if blockEndTextPosition < end {
+				return nil, htmlContext, fmt.Errorf("Fragment parsing error, missing ending block: %v", blockEndText)
+			}
+			alternative, _, err := compileTemplateInContext(t[end+len(PlaceholderEnd):blockEndTextPosition], htmlContext)
+			if err != nil {
+				return nil, htmlContext, err
+			}
+			compiled = append(compiled, &optionalIncludeNode{name: placeholder, alternative: alternative})
+			t = t[blockEndTextPosition+len(blockEndText):]
 		} else {
-			io.WriteString(w, t)
-			t = ""
+			node := newPlaceholderNode(placeholder, htmlContext)
+			if _, isVariable := node.(*variableNode); isVariable {
+				htmlContext = htmlContext.afterValue()
+			}
+			compiled = append(compiled, node)
+			t = t[end+len(PlaceholderEnd):]
+		}
+	}
+	return compiled, htmlContext, nil
+}
+
+func (compiled compiledTemplate) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	for _, node := range compiled {
+		if err := node.execute(w, data, executeNestedFragment, escaping); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func expandTemplateVars(template string, data map[string]interface{}) (string, error) {
-	buff := bytes.NewBufferString("")
-	err := executeTemplate(buff, template, data, nil)
-	return buff.String(), err
+// includes returns the names of all fragments, which may be included by the template.
+func (compiled compiledTemplate) includes() []string {
+	var names []string
+	for _, node := range compiled {
+		switch n := node.(type) {
+		case *includeNode:
+			names = append(names, n.name)
+		case *optionalIncludeNode:
+			names = append(names, n.name)
+			names = append(names, n.alternative.includes()...)
+		case *conditionalNode:
+			names = append(names, n.then.includes()...)
+			names = append(names, n.otherwise.includes()...)
+		case *loopNode:
+			names = append(names, n.body.includes()...)
+		}
+	}
+	return names
 }
 
-func writePlaceholder(w io.Writer, placeholder string, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error) error {
-	placeholder = strings.TrimSpace(placeholder)
-	if strings.HasPrefix(placeholder, StartInclude) {
-		placeholder = strings.TrimSpace(strings.TrimPrefix(placeholder, StartInclude))
-		if err := executeNestedFragment(placeholder); err != nil {
+// textNode is a static text, which is written as it is.
+type textNode string
+
+func (n textNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	_, err := io.WriteString(w, string(n))
+	return err
+}
+
+// variableNode writes a variable from the data map.
+type variableNode struct {
+	key     string
+	raw     bool
+	context escapeContext // the html context of the variable
+}
+
+func (n *variableNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	if n.raw {
+		escaping = EscapeNone
+	}
+	if d, exist := getDataFromMap(data, n.key); exist {
+		io.WriteString(w, escaping.escape(d, n.context))
+	}
+	return nil
+}
+
+// includeNode executes a required nested fragment.
+type includeNode struct {
+	name string
+}
+
+func (n *includeNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	err := executeNestedFragment(n.name)
+	if pending, isPending := err.(*pendingFragmentError); isPending {
+		// a required fragment has to be rendered in place, so we have to wait for it
+		pending.wait()
+		return executeNestedFragment(n.name)
+	}
+	return err
+}
+
+// optionalIncludeNode executes a nested fragment, or the alternative content, if this fails.
+type optionalIncludeNode struct {
+	name        string
+	alternative compiledTemplate
+}
+
+func (n *optionalIncludeNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	err := executeNestedFragment(n.name)
+	if pending, isPending := err.(*pendingFragmentError); isPending {
+		// the alternative content is shown within the placeholder, until the fragment is available
+		id := pending.addPlaceholder(func(w io.Writer, executeNestedFragment func(nestedFragmentName string) error) error {
+			return n.alternative.execute(w, data, executeNestedFragment, escaping)
+		})
+		fmt.Fprintf(w, `<%s id="%s">`, PendingPlaceholderElement, id)
+		if err := n.alternative.execute(w, data, executeNestedFragment, escaping); err != nil {
 			return err
 		}
-	} else {
-		if d, exist := getDataFromMap(data, placeholder); exist {
-			io.WriteString(w, fmt.Sprintf("%v", d))
+		fmt.Fprintf(w, `</%s>`, PendingPlaceholderElement)
+		return nil
+	}
+	if err != nil {
+		return n.alternative.execute(w, data, executeNestedFragment, escaping)
+	}
+	return nil
+}
+
+// pendingFragmentError is returned by executeNestedFragment, if a fragment is not available yet,
+// because its content is still loading.
+type pendingFragmentError struct {
+	fragmentName string
+
+	// addPlaceholder registers a placeholder for the fragment, which is filled later and returns its id.
+	// The fallback writes the alternative content, if the fragment can not be rendered later.
+	addPlaceholder func(fallback func(w io.Writer, executeNestedFragment func(nestedFragmentName string) error) error) string
+
+	// wait blocks until the pending contents are available
+	wait func()
+}
+
+func (e *pendingFragmentError) Error() string {
+	return "Fragment is pending: " + e.fragmentName
+}
+
+// conditionalNode executes one of two templates, depending on the evaluation of a condition.
+type conditionalNode struct {
+	condition string
+	then      compiledTemplate
+	otherwise compiledTemplate
+}
+
+func (n *conditionalNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	if evaluateCondition(data, n.condition) {
+		return n.then.execute(w, data, executeNestedFragment, escaping)
+	}
+	return n.otherwise.execute(w, data, executeNestedFragment, escaping)
+}
+
+// loopNode executes a template once for each element of a list.
+type loopNode struct {
+	key      string
+	variable string
+	body     compiledTemplate
+}
+
+// newLoopNode creates a loopNode out of the loop expression, e.g. 'list as item'.
+func newLoopNode(loop string) *loopNode {
+	node := &loopNode{key: loop, variable: DefaultLoopVariable}
+	if i := strings.Index(loop, LoopVariableKeyword); i > -1 {
+		node.key = strings.TrimSpace(loop[:i])
+		node.variable = strings.TrimSpace(loop[i+len(LoopVariableKeyword):])
+	}
+	return node
+}
+
+// execute executes the body once for each element of the list referenced by the loop expression.
+// Within the body, the current element is available by the loop variable name.
+// If the referenced value does not exist or is not a list, nothing is written.
+func (n *loopNode) execute(w io.Writer, data map[string]interface{}, executeNestedFragment func(nestedFragmentName string) error, escaping Escaping) error {
+	d, exist := getDataFromMap(data, n.key)
+	if !exist || d == nil {
+		return nil
+	}
+	list := reflect.ValueOf(d)
+	if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+		return nil
+	}
+
+	// shallow copy of the data, to have the loop variable only in the scope of the loop
+	scope := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		scope[k] = v
+	}
+	for i := 0; i < list.Len(); i++ {
+		scope[n.variable] = list.Index(i).Interface()
+		if err := n.body.execute(w, scope, executeNestedFragment, escaping); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// newPlaceholderNode creates the node for a simple placeholder, which is either a variable or an include.
+func newPlaceholderNode(placeholder string, htmlContext escapeContext) templateNode {
+	placeholder = strings.TrimSpace(placeholder)
+	if strings.HasPrefix(placeholder, StartInclude) {
+		return &includeNode{name: strings.TrimSpace(strings.TrimPrefix(placeholder, StartInclude))}
+	}
+	if strings.HasPrefix(placeholder, StartRawVariable) {
+		return &variableNode{key: strings.TrimSpace(strings.TrimPrefix(placeholder, StartRawVariable)), raw: true, context: htmlContext}
+	}
+	return &variableNode{key: placeholder, context: htmlContext}
+}
+
+// findBlock searches the end of a block within t, where t is the template text directly behind the start of the block.
+// It returns the positions of the optional else marker and of the end marker, respecting nested blocks,
+// which are identified by placeholders starting with startPrefix.
+// If there is no else marker, elseStart is equal to blockEnd and elseEnd is behind the end marker.
+func findBlock(t, startPrefix, elseMarker, endMarker string) (elseStart, elseEnd, blockEnd int, err error) {
+	elseStart = -1
+	depth := 0
+	pos := 0
+	for {
+		next := strings.Index(t[pos:], PlaceholderStart)
+		if next == -1 {
+			return 0, 0, 0, fmt.Errorf("Fragment parsing error, missing ending block: %v", endMarker)
+		}
+		pos += next
+		switch {
+		case elseMarker != "" && strings.HasPrefix(t[pos:], elseMarker):
+			if depth == 0 {
+				if elseStart != -1 {
+					return 0, 0, 0, fmt.Errorf("Fragment parsing error, multiple else blocks: %v", elseMarker)
+				}
+				elseStart = pos
+				elseEnd = pos + len(elseMarker)
+			}
+		case strings.HasPrefix(t[pos:], endMarker):
+			if depth == 0 {
+				if elseStart == -1 {
+					elseStart = pos
+					elseEnd = pos + len(endMarker)
+				}
+				return elseStart, elseEnd, pos, nil
+			}
+			depth--
+		case strings.HasPrefix(t[pos+len(PlaceholderStart):], startPrefix):
+			depth++
+		}
+		pos += len(PlaceholderStart)
+	}
+}
+
+// evaluateCondition evaluates a condition against the data map.
+// The paths within the condition are resolved by getDataFromMap().
+// The following forms are supported:
+// path          true, if the value exists and is not empty, false or zero
+// !path         true, if the value does not exist or is empty, false or zero
+// exists path   true, if the value exists
+// path == value true, if the value exists and is equal to value
+// path != value true, if the value does not exist or is not equal to value
+// The compared value may be enclosed in single or double quotes.
+func evaluateCondition(data map[string]interface{}, condition string) bool {
+	condition = strings.TrimSpace(condition)
+
+	if strings.HasPrefix(condition, ConditionExistsKeyword) {
+		_, exist := getDataFromMap(data, strings.TrimSpace(strings.TrimPrefix(condition, ConditionExistsKeyword)))
+		return exist
+	}
+
+	for _, operator := range []string{"==", "!="} {
+		if i := strings.Index(condition, operator); i > -1 {
+			key := strings.TrimSpace(condition[:i])
+			value := unquote(strings.TrimSpace(condition[i+len(operator):]))
+			d, exist := getDataFromMap(data, key)
+			equal := exist && fmt.Sprintf("%v", d) == value
+			return equal == (operator == "==")
+		}
+	}
+
+	if strings.HasPrefix(condition, "!") {
+		return !evaluateCondition(data, condition[1:])
+	}
+
+	d, exist := getDataFromMap(data, condition)
+	return exist && isTruthy(d)
+}
+
+// isTruthy returns false for nil, false, zero numbers and empty strings, slices and maps.
+func isTruthy(d interface{}) bool {
+	if d == nil {
+		return false
+	}
+	v := reflect.ValueOf(d)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	}
+	return true
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 {
+		if (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// expandTemplateVars expands the variables of an url template, like the url of a fetch definition.
+func expandTemplateVars(template string, data map[string]interface{}, escaping Escaping) (string, error) {
+	compiled, _, err := compileTemplateInContext(template, escapeContext{state: stateUrl})
+	if err != nil {
+		return "", err
+	}
+	buff := bytes.NewBufferString("")
+	err = compiled.execute(buff, data, nil, escaping)
+	return buff.String(), err
+}
+
 // getDataFromMap returns the data defined by a key,
 // where the key may contain multiple path elements separated by a '.'.
 // If the map contains the full key on top-level, this value is preferred.
@@ -87,7 +87,7 @@ func Test_Templating_Variables(t *testing.T) {
 	for _, test := range tests {
 
 		buf := bytes.NewBufferString("")
-		err := executeTemplate(buf, test.template, test.data, nil)
+		err := executeTemplate(buf, test.template, test.data, nil, EscapeHtml)
 
 		a.NoError(err)
 
@@ -97,9 +97,60 @@ func Test_Templating_Variables(t *testing.T) {
 
 func Test_expandTemplateVars(t *testing.T) {
 	a := assert.New(t)
-	result, err := expandTemplateVars("§[foo]§", map[string]interface{}{"foo": "bar"})
+	result, err := expandTemplateVars("§[foo]§", map[string]interface{}{"foo": "bar"}, EscapeUrl)
 	a.NoError(err)
 	a.Equal("bar", result)
+
+	data := map[string]interface{}{"q": "a b&c=d", "base_url": "http://example.com"}
+	result, err = expandTemplateVars("§[& base_url]§/search?q=§[q]§", data, EscapeUrl)
+	a.NoError(err)
+	a.Equal("http://example.com/search?q=a+b%26c%3Dd", result)
+
+	result, err = expandTemplateVars("§[ base_url]§/search?q=§[q]§", data, EscapeNone)
+	a.NoError(err)
+	a.Equal("http://example.com/search?q=a b&c=d", result)
+
+	// the escaping depends on the part of the url
+	data["path"] = "a/b c"
+	result, err = expandTemplateVars("§[ base_url]§/products/§[ path ]§?q=§[q]§#§[ path ]§", data, EscapeUrl)
+	a.NoError(err)
+	a.Equal("http://example.com/products/a%2Fb%20c?q=a+b%26c%3Dd#a%2Fb+c", result)
+
+	data["base_url"] = "http://example.com/ä b"
+	result, err = expandTemplateVars("§[ base_url]§/search", data, EscapeUrl)
+	a.NoError(err)
+	a.Equal("http://example.com/%C3%A4%20b/search", result)
+}
+
+func Test_Templating_Escaping(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"q":      `<script>alert("x")</script>`,
+		"markup": "<b>bold</b>",
+		"list":   []interface{}{"<i>", "&"},
+	}
+
+	tests := []struct {
+		template string
+		escaping Escaping
+		expected string
+	}{
+		{"<p>§[ q ]§</p>", EscapeHtml, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>"},
+		{`<a title="§[ q ]§">`, EscapeHtml, `<a title="&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;">`},
+		{"§[& markup ]§", EscapeHtml, "<b>bold</b>"},
+		{"§[&markup]§", EscapeUrl, "<b>bold</b>"},
+		{"§[ markup ]§", EscapeUrl, "%3Cb%3Ebold%3C%2Fb%3E"},
+		{"§[ markup ]§", EscapeNone, "<b>bold</b>"},
+		{"§[#each list]§§[ this ]§§[/each]§", EscapeHtml, "&lt;i&gt;&amp;"},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil, test.escaping)
+		a.NoError(err)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
 }
 
 func Test_Templating_Includes(t *testing.T) {
@@ -148,6 +199,11 @@ func Test_Templating_Includes(t *testing.T) {
 			template:  "xxx-§[#> foo]§ alternative text §[/foo]§-yyy",
 			expected:  "xxx- alternative text -yyy",
 		},
+		{
+			fragments: map[string]string{"bar": "bar"},
+			template:  "xxx-§[#> foo]§ alternative §[#> bar]§ nested §[/bar]§§[#> bazz]§ nested §[/bazz]§-§[/foo]§-yyy",
+			expected:  "xxx- alternative bar nested --yyy",
+		},
 		{
 			fragments:   map[string]string{},
 			template:    "xxx-§[#> foo]§ alternative text §-yyy",
@@ -164,7 +220,7 @@ func Test_Templating_Includes(t *testing.T) {
 			}
 			return errors.New("Fragment does not exist: " + nestedFragmentName)
 		}
-		err := executeTemplate(buf, test.template, nil, executeNestedFragment)
+		err := executeTemplate(buf, test.template, nil, executeNestedFragment, EscapeHtml)
 
 		if test.expectedErr == nil {
 			a.NoError(err)
@@ -176,6 +232,133 @@ func Test_Templating_Includes(t *testing.T) {
 	}
 }
 
+func Test_Templating_Conditionals(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"loggedIn": true,
+		"empty":    "",
+		"zero":     float64(0),
+		"user":     map[string]interface{}{"name": "Alice", "role": "admin"},
+		"items":    []interface{}{},
+		"params":   url.Values{"q": {"shoes"}},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"§[? loggedIn ]§in§[/?]§", "in"},
+		{"§[? loggedIn ]§in§[?else]§out§[/?]§", "in"},
+		{"§[? !loggedIn ]§in§[?else]§out§[/?]§", "out"},
+		{"§[? notExisting ]§yes§[?else]§no§[/?]§", "no"},
+		{"§[? empty ]§yes§[?else]§no§[/?]§", "no"},
+		{"§[? zero ]§yes§[?else]§no§[/?]§", "no"},
+		{"§[? items ]§yes§[?else]§no§[/?]§", "no"},
+		{"§[? exists empty ]§yes§[?else]§no§[/?]§", "yes"},
+		{"§[? exists user.email ]§yes§[?else]§no§[/?]§", "no"},
+		{"§[? user.role == admin ]§yes§[?else]§no§[/?]§", "yes"},
+		{"§[? user.role == 'admin' ]§yes§[?else]§no§[/?]§", "yes"},
+		{`§[? user.role != "admin" ]§yes§[?else]§no§[/?]§`, "no"},
+		{"§[? params.q == shoes ]§yes§[?else]§no§[/?]§", "yes"},
+		{"xxx-§[? user.name ]§Hello §[ user.name ]§§[/?]§-yyy", "xxx-Hello Alice-yyy"},
+		{"§[? loggedIn ]§a§[? user.role == admin ]§b§[?else]§c§[/?]§d§[?else]§e§[? loggedIn ]§f§[/?]§§[/?]§", "abd"},
+		{"§[? !loggedIn ]§a§[? loggedIn ]§b§[?else]§c§[/?]§d§[?else]§e§[? loggedIn ]§f§[/?]§g§[/?]§", "efg"},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil, EscapeHtml)
+		a.NoError(err)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+}
+
+func Test_Templating_Conditionals_ParsingErrors(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		template          string
+		expectedErrString string
+	}{
+		{
+			template:          "xxx-§[? foo]§-yyy",
+			expectedErrString: "Fragment parsing error, missing ending block: §[/?]§",
+		},
+		{
+			template:          "§[? foo]§§[? bar]§§[/?]§",
+			expectedErrString: "Fragment parsing error, missing ending block: §[/?]§",
+		},
+		{
+			template:          "§[? foo]§a§[?else]§b§[?else]§c§[/?]§",
+			expectedErrString: "Fragment parsing error, multiple else blocks",
+		},
+		{
+			template:          "xxx-§[?else]§-yyy",
+			expectedErrString: "Fragment parsing error, §[?else]§ without conditional block",
+		},
+		{
+			template:          "§[? foo]§a§[/?]§b§[/?]§",
+			expectedErrString: "Fragment parsing error, §[/?]§ without conditional block",
+		},
+		{
+			template:          "§[?   ]§a§[/?]§",
+			expectedErrString: "Fragment parsing error, conditional block without condition",
+		},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, map[string]interface{}{}, nil, EscapeHtml)
+		a.Error(err)
+		a.Contains(err.Error(), test.expectedErrString)
+	}
+}
+
+func Test_Templating_Loops(t *testing.T) {
+	a := assert.New(t)
+
+	data := map[string]interface{}{
+		"categories": []interface{}{"animal", "human"},
+		"nav": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"title": "Home", "children": []interface{}{"a", "b"}},
+				map[string]interface{}{"title": "Shop", "children": []interface{}{}},
+			},
+		},
+		"tags":   []string{"x", "y"},
+		"noList": "foo",
+		"title":  "global",
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"§[#each categories]§<li>§[ this ]§</li>§[/each]§", "<li>animal</li><li>human</li>"},
+		{"§[#each tags as tag ]§§[ tag ]§,§[/each]§", "x,y,"},
+		{"§[#each nav.items as item]§§[ item.title ]§ §[/each]§", "Home Shop "},
+		{"§[#each nav.items as item]§§[ item.title ]§:§[#each item.children as child]§§[ child ]§§[/each]§;§[/each]§", "Home:ab;Shop:;"},
+		{"§[#each nav.items as item]§§[? item.children ]§§[ item.title ]§§[/?]§§[/each]§", "Home"},
+		{"§[#each nav.items as item]§§[ title ]§§[/each]§", "globalglobal"},
+		{"xxx§[#each notExisting]§§[ this ]§§[/each]§yyy", "xxxyyy"},
+		{"xxx§[#each noList]§§[ this ]§§[/each]§yyy", "xxxyyy"},
+		{"§[#each categories as c]§§[/each]§§[ c ]§", ""},
+	}
+
+	for _, test := range tests {
+		buf := bytes.NewBufferString("")
+		err := executeTemplate(buf, test.template, data, nil, EscapeHtml)
+		a.NoError(err)
+		a.Equal(test.expected, buf.String(), test.template)
+	}
+
+	buf := bytes.NewBufferString("")
+	err := executeTemplate(buf, "§[#each categories]§§[ this ]§", data, nil, EscapeHtml)
+	a.Error(err)
+	a.Contains(err.Error(), "Fragment parsing error, missing ending block: §[/each]§")
+}
+
 func Test_Templating_ParsingErrors(t *testing.T) {
 	a := assert.New(t)
 
@@ -198,7 +381,7 @@ func Test_Templating_ParsingErrors(t *testing.T) {
 		executeNestedFragment := func(nestedFragmentName string) error {
 			return nil
 		}
-		err := executeTemplate(buf, test.template, map[string]interface{}{}, executeNestedFragment)
+		err := executeTemplate(buf, test.template, map[string]interface{}{}, executeNestedFragment, EscapeHtml)
 		a.Error(err)
 		a.Contains(err.Error(), test.expectedErrString)
 	}
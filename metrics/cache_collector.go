@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"github.com/tarent/lib-compose/v2/cache"
+	"io"
+	"sync"
+)
+
+// StatsCache is a cache with statistics, like cache.Cache and cache.ShardedCache.
+type StatsCache interface {
+	Stats() cache.Stats
+}
+
+// CacheCollector writes the statistics of caches, labeled by the name of the cache.
+type CacheCollector struct {
+	mutex  sync.Mutex
+	caches []StatsCache
+}
+
+// RegisterCache adds the statistics of the cache to the metrics of the registry.
+func (r *Registry) RegisterCache(c StatsCache) {
+	r.mutex.Lock()
+	var collector *CacheCollector
+	for _, registered := range r.collectors {
+		if cacheCollector, ok := registered.(*CacheCollector); ok {
+			collector = cacheCollector
+		}
+	}
+	if collector == nil {
+		collector = &CacheCollector{}
+		r.collectors = append(r.collectors, collector)
+	}
+	r.mutex.Unlock()
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.caches = append(collector.caches, c)
+}
+
+func (cc *CacheCollector) WriteMetrics(w io.Writer) {
+	cc.mutex.Lock()
+	stats := make([]cache.Stats, 0, len(cc.caches))
+	for _, c := range cc.caches {
+		stats = append(stats, c.Stats())
+	}
+	cc.mutex.Unlock()
+
+	metrics := []struct {
+		name       string
+		help       string
+		metricType string
+		value      func(s cache.Stats) int
+	}{
+		{"compose_cache_hits_total", "Number of cache hits.", "counter", func(s cache.Stats) int { return s.Hits }},
+		{"compose_cache_misses_total", "Number of cache misses.", "counter", func(s cache.Stats) int { return s.Misses }},
+		{"compose_cache_evictions_total", "Number of entries evicted by the size limits.", "counter", func(s cache.Stats) int { return s.Evictions }},
+		{"compose_cache_size_bytes", "Memory size of the cache entries.", "gauge", func(s cache.Stats) int { return s.SizeBytes }},
+		{"compose_cache_entries", "Number of cache entries.", "gauge", func(s cache.Stats) int { return s.Entries }},
+	}
+	for _, m := range metrics {
+		WriteHeader(w, m.name, m.help, m.metricType)
+		for _, s := range stats {
+			WriteSample(w, m.name+Labels("cache", s.Name), float64(m.value(s)))
+		}
+	}
+}
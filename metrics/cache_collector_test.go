@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarent/lib-compose/v2/cache"
+	"testing"
+	"time"
+)
+
+func Test_CacheCollector(t *testing.T) {
+	a := assert.New(t)
+
+	fragments := cache.NewCache("fragments", 100, 100, time.Hour)
+	fragments.Set("a", "", 42, "a", time.Time{})
+	fragments.Get("a")
+	fragments.Get("b")
+	pages := cache.NewShardedCache("pages", 4, 100, 100, time.Hour)
+
+	r := NewRegistry()
+	r.RegisterCache(fragments)
+	r.RegisterCache(pages)
+
+	b := &bytes.Buffer{}
+	r.WriteMetrics(b)
+	a.Equal(`# HELP compose_cache_hits_total Number of cache hits.
+# TYPE compose_cache_hits_total counter
+compose_cache_hits_total{cache="fragments"} 1
+compose_cache_hits_total{cache="pages"} 0
+# HELP compose_cache_misses_total Number of cache misses.
+# TYPE compose_cache_misses_total counter
+compose_cache_misses_total{cache="fragments"} 1
+compose_cache_misses_total{cache="pages"} 0
+# HELP compose_cache_evictions_total Number of entries evicted by the size limits.
+# TYPE compose_cache_evictions_total counter
+compose_cache_evictions_total{cache="fragments"} 0
+compose_cache_evictions_total{cache="pages"} 0
+# HELP compose_cache_size_bytes Memory size of the cache entries.
+# TYPE compose_cache_size_bytes gauge
+compose_cache_size_bytes{cache="fragments"} 42
+compose_cache_size_bytes{cache="pages"} 0
+# HELP compose_cache_entries Number of cache entries.
+# TYPE compose_cache_entries gauge
+compose_cache_entries{cache="fragments"} 1
+compose_cache_entries{cache="pages"} 0
+`, b.String())
+}
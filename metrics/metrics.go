@@ -0,0 +1,242 @@
+// Package metrics provides counters and histograms, which are served in the Prometheus text format,
+// without a dependency to the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of histograms for durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry of the metrics of the composition.
+var Default = NewRegistry()
+
+// Collector writes metrics in the Prometheus text format.
+type Collector interface {
+	WriteMetrics(w io.Writer)
+}
+
+// Registry is a set of collectors, which is served in the Prometheus text format.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the collector to the registry.
+func (r *Registry) Register(c Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// NewCounter creates and registers a counter with the label names.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, labelNames)}
+	r.Register(c)
+	return c
+}
+
+// NewHistogram creates and registers a histogram with the upper bounds of the buckets and the label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{vec: newVec(name, help, labelNames), buckets: buckets}
+	r.Register(h)
+	return h
+}
+
+// WriteMetrics writes the metrics of all collectors.
+func (r *Registry) WriteMetrics(w io.Writer) {
+	r.mutex.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mutex.Unlock()
+
+	for _, c := range collectors {
+		c.WriteMetrics(w)
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	r.WriteMetrics(bw)
+	bw.Flush()
+}
+
+// vec holds the values of a metric per combination of label values.
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	mutex      sync.Mutex
+	values     map[string]interface{}
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{name: name, help: help, labelNames: labelNames, values: make(map[string]interface{})}
+}
+
+// value returns the value for the label values, created by newValue, if it does not exist.
+// The method has to be called in a locked mutex block.
+func (v *vec) value(labelValues []string, newValue func() interface{}) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %v has %v labels, but got %v values", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	value, exist := v.values[key]
+	if !exist {
+		value = newValue()
+		v.values[key] = value
+	}
+	return value
+}
+
+// sortedKeys returns the keys of the values in a stable order.
+// The method has to be called in a locked mutex block.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labels formats the label names with the values of the key, and the additional label pairs.
+func (v *vec) labels(key string, additional ...string) string {
+	var pairs []string
+	if len(v.labelNames) > 0 {
+		for i, labelValue := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labelNames[i]+`="`+escapeLabelValue(labelValue)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(additional); i += 2 {
+		pairs = append(pairs, additional[i]+`="`+escapeLabelValue(additional[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonic increasing value per combination of label values.
+type Counter struct {
+	vec
+}
+
+type counterValue struct {
+	value float64
+}
+
+// Inc increments the counter for the label values by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the label values.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value(labelValues, func() interface{} { return &counterValue{} }).(*counterValue).value += delta
+}
+
+func (c *Counter) WriteMetrics(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	WriteHeader(w, c.name, c.help, "counter")
+	for _, key := range c.sortedKeys() {
+		WriteSample(w, c.name+c.labels(key), c.values[key].(*counterValue).value)
+	}
+}
+
+// Histogram counts observations in buckets per combination of label values.
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64 // the non cumulative counts per bucket
+	sum    float64
+	count  uint64
+}
+
+// Observe adds an observation for the label values.
+func (h *Histogram) Observe(observation float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	value := h.value(labelValues, func() interface{} {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramValue)
+	for i, upperBound := range h.buckets {
+		if observation <= upperBound {
+			value.counts[i]++
+			break
+		}
+	}
+	value.sum += observation
+	value.count++
+}
+
+func (h *Histogram) WriteMetrics(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	WriteHeader(w, h.name, h.help, "histogram")
+	for _, key := range h.sortedKeys() {
+		value := h.values[key].(*histogramValue)
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += value.counts[i]
+			WriteSample(w, h.name+"_bucket"+h.labels(key, "le", formatFloat(upperBound)), float64(cumulative))
+		}
+		WriteSample(w, h.name+"_bucket"+h.labels(key, "le", "+Inf"), float64(value.count))
+		WriteSample(w, h.name+"_sum"+h.labels(key), value.sum)
+		WriteSample(w, h.name+"_count"+h.labels(key), float64(value.count))
+	}
+}
+
+// WriteHeader writes the HELP and TYPE lines of a metric.
+func WriteHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %v %v\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %v %v\n", name, metricType)
+}
+
+// WriteSample writes a sample line. The name includes the formatted labels, if any.
+func WriteSample(w io.Writer, nameWithLabels string, value float64) {
+	fmt.Fprintf(w, "%v %v\n", nameWithLabels, formatFloat(value))
+}
+
+// Labels formats the label pairs of name and value.
+func Labels(nameValuePairs ...string) string {
+	v := vec{}
+	return v.labels("", nameValuePairs...)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
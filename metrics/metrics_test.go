@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Counter(t *testing.T) {
+	a := assert.New(t)
+
+	r := NewRegistry()
+	c := r.NewCounter("fetches_total", "Number of fetches.", "name", "outcome")
+	c.Inc("nav", "success")
+	c.Inc("nav", "success")
+	c.Add(3, "teaser", `error "500"`)
+
+	b := &bytes.Buffer{}
+	r.WriteMetrics(b)
+	a.Equal(`# HELP fetches_total Number of fetches.
+# TYPE fetches_total counter
+fetches_total{name="nav",outcome="success"} 2
+fetches_total{name="teaser",outcome="error \"500\""} 3
+`, b.String())
+
+	a.Panics(func() { c.Inc("nav") })
+}
+
+func Test_Histogram(t *testing.T) {
+	a := assert.New(t)
+
+	r := NewRegistry()
+	h := r.NewHistogram("duration_seconds", "Duration.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	b := &bytes.Buffer{}
+	r.WriteMetrics(b)
+	a.Equal(`# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 5.55
+duration_seconds_count 3
+`, b.String())
+}
+
+func Test_Registry_ServeHTTP(t *testing.T) {
+	a := assert.New(t)
+
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Number of requests.").Inc()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	r.ServeHTTP(w, req)
+
+	a.Equal(200, w.Code)
+	a.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	a.Contains(w.Body.String(), "requests_total 1\n")
+}